@@ -1,11 +1,27 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+
 	"github.com/bhcleek/packer-provisioner-ansible/provisioner/ansible"
+	"github.com/mitchellh/packer/packer"
 	"github.com/mitchellh/packer/packer/plugin"
 )
 
+// description is the output of the describe command.
+type description struct {
+	Version      string   `json:"version"`
+	PackerAPI    string   `json:"packer_api_version"`
+	Provisioners []string `json:"provisioners"`
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(command(os.Args[1]))
+	}
+
 	server, err := plugin.Server()
 	if err != nil {
 		panic(err)
@@ -13,3 +29,24 @@ func main() {
 	server.RegisterProvisioner(new(ansible.Provisioner))
 	server.Serve()
 }
+
+// command runs a command that is given on the command line instead of serving
+// the provisioner to Packer, and returns the exit status.
+func command(name string) int {
+	switch name {
+	case "version", "-version", "--version":
+		fmt.Printf("packer-provisioner-ansible v%s\n", versionString())
+	case "describe":
+		b, _ := json.MarshalIndent(description{
+			Version:      versionString(),
+			PackerAPI:    packer.Version,
+			Provisioners: []string{"ansible"},
+		}, "", "  ")
+		fmt.Println(string(b))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q; the commands are version and describe.\n", name)
+		fmt.Fprintln(os.Stderr, "Without a command, the plugin must be run by Packer.")
+		return 1
+	}
+	return 0
+}
@@ -1 +1,15 @@
 package main
+
+import "testing"
+
+func TestCommand(t *testing.T) {
+	for name, expected := range map[string]int{
+		"version":  0,
+		"describe": 0,
+		"serve":    1,
+	} {
+		if status := command(name); status != expected {
+			t.Fatalf("expected exit status %d for %s, got %d", expected, name, status)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import "fmt"
+
+// The git commit that was compiled. This will be filled in by the compiler.
+var GitCommit string
+
+// The main version number of the plugin.
+const Version = "0.1.0"
+
+// A pre-release marker for the version. If this is "" (empty string) then it
+// means that it is a final release. Otherwise, this is a pre-release such as
+// "dev" (in development), "beta", "rc1", etc.
+const VersionPrerelease = "dev"
+
+// versionString returns the full version of the plugin, e.g. 0.1.0-dev
+// (abc1234).
+func versionString() string {
+	v := Version
+	if VersionPrerelease != "" {
+		v = fmt.Sprintf("%s-%s", v, VersionPrerelease)
+	}
+	if GitCommit != "" {
+		v = fmt.Sprintf("%s (%s)", v, GitCommit)
+	}
+	return v
+}
@@ -7,18 +7,31 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strings"
+	"sync/atomic"
 
 	"github.com/mitchellh/packer/packer"
 	"golang.org/x/crypto/ssh"
 )
 
 type adapter struct {
+	// counters of the connections, sessions, and commands handled; accessed
+	// atomically. They are first in the struct so that they are 64-bit aligned
+	// on 32-bit platforms, as the atomic operations require.
+	connections uint64
+	sessions    uint64
+	commands    uint64
+
 	done    <-chan struct{}
 	l       net.Listener
 	config  *ssh.ServerConfig
 	sftpCmd string
 	ui      packer.Ui
 	comm    packer.Communicator
+
+	// windows is set when the machine runs Windows, which has no /bin/sh and
+	// a different sftp server.
+	windows bool
 }
 
 func newAdapter(done <-chan struct{}, l net.Listener, config *ssh.ServerConfig, sftpCmd string, ui packer.Ui, comm packer.Communicator) *adapter {
@@ -59,6 +72,7 @@ func (c *adapter) Serve() {
 
 func (c *adapter) Handle(conn net.Conn, ui packer.Ui) error {
 	c.ui.Message("SSH proxy: accepted connection")
+	atomic.AddUint64(&c.connections, 1)
 	_, chans, reqs, err := ssh.NewServerConn(conn, c.config)
 	if err != nil {
 		return errors.New("failed to handshake")
@@ -90,6 +104,7 @@ func (c *adapter) handleSession(newChannel ssh.NewChannel) error {
 		return err
 	}
 	defer channel.Close()
+	atomic.AddUint64(&c.sessions, 1)
 
 	done := make(chan struct{})
 
@@ -123,26 +138,15 @@ func (c *adapter) handleSession(newChannel ssh.NewChannel) error {
 				}
 
 				if len(req.Payload) > 0 {
-					cmd := &packer.RemoteCmd{
-						Stdin:   channel,
-						Stdout:  channel,
-						Stderr:  channel.Stderr(),
-						Command: string(req.Payload),
+					atomic.AddUint64(&c.commands, 1)
+					command := string(req.Payload)
+					if c.windows {
+						command = unwrapShellCommand(command)
 					}
-
-					if err := c.comm.Start(cmd); err != nil {
-						c.ui.Error(err.Error())
-						close(done)
-						return
-					}
-					go func(cmd *packer.RemoteCmd, channel ssh.Channel) {
-						cmd.Wait()
-
-						exitStatus := make([]byte, 4)
-						binary.BigEndian.PutUint32(exitStatus, uint32(cmd.ExitStatus))
-						channel.SendRequest("exit-status", false, exitStatus)
+					go func() {
+						c.exec(command, channel)
 						close(done)
-					}(cmd, channel)
+					}()
 				}
 
 			case "subsystem":
@@ -159,6 +163,9 @@ func (c *adapter) handleSession(newChannel ssh.NewChannel) error {
 					sftpCmd := c.sftpCmd
 					if len(sftpCmd) == 0 {
 						sftpCmd = "/usr/lib/sftp-server -e"
+						if c.windows {
+							sftpCmd = "sftp-server.exe"
+						}
 					}
 					cmd := &packer.RemoteCmd{
 						Stdin:   channel,
@@ -191,10 +198,106 @@ func (c *adapter) handleSession(newChannel ssh.NewChannel) error {
 	return nil
 }
 
+// exec runs command on the machine with the channel as its stdin, stdout, and
+// stderr, and then reports its exit status. The output is relayed unchanged,
+// so that the output of raw and script modules, which need no Python on the
+// machine, reaches Ansible byte for byte, and the channel is closed for
+// writing before the exit status is sent, so that none of it is lost. A
+// command that cannot be started exits with 255, which Ansible reports as an
+// unreachable machine.
+func (c *adapter) exec(command string, channel ssh.Channel) {
+	cmd := &packer.RemoteCmd{
+		Stdin:   channel,
+		Stdout:  channel,
+		Stderr:  channel.Stderr(),
+		Command: command,
+	}
+
+	status := 255
+	if err := c.comm.Start(cmd); err != nil {
+		c.ui.Error(err.Error())
+		fmt.Fprintln(channel.Stderr(), err)
+	} else {
+		cmd.Wait()
+		status = cmd.ExitStatus
+	}
+
+	channel.CloseWrite()
+	channel.SendRequest("exit-status", false, exitStatusPayload(status))
+}
+
+// exitStatusPayload returns the payload of the exit-status request of a
+// command that exited with status. A negative status, of a command whose
+// status the communicator lost, is reported as 255, like OpenSSH does.
+// see RFC 4254, section 6.10
+func exitStatusPayload(status int) []byte {
+	if status < 0 {
+		status = 255
+	}
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(status))
+	return payload
+}
+
+// unwrapShellCommand returns the command that command runs with /bin/sh -c,
+// for machines without /bin/sh. Other commands are returned unchanged.
+func unwrapShellCommand(command string) string {
+	const prefix = "/bin/sh -c "
+	if !strings.HasPrefix(command, prefix) {
+		return command
+	}
+
+	// Undo the shell quoting with which ansible quotes the command. Anything
+	// but a single quoted word is left to the machine's shell.
+	var b bytes.Buffer
+	var quote rune
+	escaped := false
+	for _, r := range command[len(prefix):] {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				b.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\', '$', '`':
+				return command
+			default:
+				b.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '\\':
+			escaped = true
+		case strings.ContainsRune(" \t\n;&|<>()$`*?", r):
+			return command
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if quote != 0 || escaped {
+		return command
+	}
+	return b.String()
+}
+
 func (c *adapter) Shutdown() {
 	c.l.Close()
 }
 
+// Stats returns the number of connections, sessions, and commands that have
+// been handled.
+func (c *adapter) Stats() (connections, sessions, commands uint64) {
+	return atomic.LoadUint64(&c.connections), atomic.LoadUint64(&c.sessions), atomic.LoadUint64(&c.commands)
+}
+
 type envRequest struct {
 	*ssh.Request
 	Payload envRequestPayload
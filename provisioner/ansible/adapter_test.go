@@ -1,6 +1,8 @@
 package ansible
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"io"
 	"log"
@@ -140,3 +142,93 @@ func (c communicator) UploadDir(dst string, src string, exclude []string) error
 func (c communicator) Download(string, io.Writer) error {
 	return errors.New("communicator not supported")
 }
+
+// sessionChannel records what is written to a session and the requests sent to the
+// client.
+type sessionChannel struct {
+	bytes.Buffer
+	stderr   bytes.Buffer
+	closed   bool
+	requests []string
+	status   []byte
+}
+
+func (c *sessionChannel) Close() error      { return nil }
+func (c *sessionChannel) CloseWrite() error { c.closed = true; return nil }
+
+func (c *sessionChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	if !c.closed {
+		return false, errors.New("request before EOF")
+	}
+	c.requests = append(c.requests, name)
+	c.status = payload
+	return true, nil
+}
+
+func (c *sessionChannel) Stderr() io.ReadWriter { return &c.stderr }
+
+// rawCommunicator runs commands without Python, as the raw module does on a
+// network appliance: it writes output to stdout and exits with status.
+type rawCommunicator struct {
+	communicator
+	output []byte
+	status int
+}
+
+func (c rawCommunicator) Start(cmd *packer.RemoteCmd) error {
+	cmd.Stdout.Write(c.output)
+	cmd.SetExited(c.status)
+	return nil
+}
+
+func TestAdapter_Exec(t *testing.T) {
+	output := []byte("\x00\xff\r\nshow version\x1b[0m\n")
+	sut := newAdapter(nil, nil, nil, "", new(ui), rawCommunicator{output: output, status: 3})
+
+	var ch sessionChannel
+	sut.exec("show version", &ch)
+	if !bytes.Equal(ch.Bytes(), output) {
+		t.Fatalf("expected stdout %q, got %q", output, ch.Bytes())
+	}
+	if len(ch.requests) != 1 || ch.requests[0] != "exit-status" {
+		t.Fatalf("expected an exit-status request, got %v", ch.requests)
+	}
+	if status := binary.BigEndian.Uint32(ch.status); status != 3 {
+		t.Fatalf("expected exit status 3, got %d", status)
+	}
+
+	ch = sessionChannel{}
+	sut = newAdapter(nil, nil, nil, "", new(ui), communicator{})
+	sut.exec("show version", &ch)
+	if status := binary.BigEndian.Uint32(ch.status); status != 255 {
+		t.Fatalf("expected exit status 255, got %d", status)
+	}
+	if ch.stderr.Len() == 0 {
+		t.Fatal("expected the error on stderr")
+	}
+}
+
+func TestExitStatusPayload(t *testing.T) {
+	for status, expected := range map[int]uint32{0: 0, 1: 1, 255: 255, -1: 255, 1 << 16: 1 << 16} {
+		if got := binary.BigEndian.Uint32(exitStatusPayload(status)); got != expected {
+			t.Errorf("%d: expected %d, got %d", status, expected, got)
+		}
+	}
+}
+
+func TestUnwrapShellCommand(t *testing.T) {
+	cases := map[string]string{
+		`/bin/sh -c 'echo ok'`:            `echo ok`,
+		`/bin/sh -c 'echo '"'"'ok'"'"''`:  `echo 'ok'`,
+		`/bin/sh -c 'echo '\''ok'\'''`:    `echo 'ok'`,
+		`/bin/sh -c 'dir C:\'`:            `dir C:\`,
+		`/bin/sh -c 'echo' 'ok'`:          `/bin/sh -c 'echo' 'ok'`,
+		`/bin/sh -c 'unterminated`:        `/bin/sh -c 'unterminated`,
+		`powershell -EncodedCommand AAAA`: `powershell -EncodedCommand AAAA`,
+	}
+	for command, expected := range cases {
+		if got := unwrapShellCommand(command); got != expected {
+			t.Errorf("unwrapShellCommand(%q): expected %q, got %q", command, expected, got)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package ansible
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// prepareModule checks the options of running an ad-hoc module instead of
+// the playbooks.
+func (p *Provisioner) prepareModule() []error {
+	if p.config.Module == "" {
+		if p.config.ModuleArgs != "" {
+			return []error{errors.New("module_args requires module")}
+		}
+		return nil
+	}
+	var errs []error
+	for option, set := range map[string]bool{
+		"remote_execution": p.config.RemoteExecution,
+		"pull_repo":        p.config.PullRepo != "",
+		"use_navigator":    p.config.UseNavigator,
+		"use_runner":       p.config.UseRunner,
+	} {
+		if set {
+			errs = append(errs, fmt.Errorf("module cannot be used with %s", option))
+		}
+	}
+	return errs
+}
+
+// moduleCommand returns the command that runs module, with module_args, against
+// the inventory.
+func (p *Provisioner) moduleCommand() *exec.Cmd {
+	var args []string
+	if p.config.ModuleArgs != "" {
+		args = append(args, "-a", p.config.ModuleArgs)
+	}
+	return p.adhocCommand(p.config.Module, args...)
+}
+
+// executeModule runs module against the machine through the inventory, as the
+// playbooks would be run.
+func (p *Provisioner) executeModule(ui packer.Ui) error {
+	cmd := p.moduleCommand()
+	ui.Say(fmt.Sprintf("Executing Ansible module %s: %s", p.config.Module, p.redactSecrets(strings.Join(cmd.Args, " "))))
+
+	output := &unreachableUi{Ui: ui}
+	stdout, stderr := output.Message, output.Error
+	if p.config.LogFile != "" {
+		f, err := os.Create(p.config.LogFile)
+		if err != nil {
+			return fmt.Errorf("Error creating log_file: %s", err)
+		}
+		defer f.Close()
+		log := &lineLog{w: f}
+		stdout, stderr = log.tee(stdout), log.tee(stderr)
+	}
+
+	if err := runCommand(ui, cmd, stdout, stderr); err != nil {
+		ui.Error("To reproduce the failure, run:")
+		ui.Error("    " + p.reproduction(cmd))
+		if status, signaled, ok := exitStatus(err); ok {
+			aerr := newAnsibleError(status, signaled, nil, output.unreachable(), err)
+			aerr.report(ui)
+			return aerr
+		}
+		return fmt.Errorf("Non-zero exit status: %s", err)
+	}
+	return nil
+}
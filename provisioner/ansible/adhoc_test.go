@@ -0,0 +1,86 @@
+package ansible
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProvisionerPrepare_Module(t *testing.T) {
+	publickey_file, err := ioutil.TempFile("", "publickey")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(publickey_file.Name())
+
+	var p Provisioner
+	config := testConfig()
+	config["ssh_authorized_key_file"] = publickey_file.Name()
+	config["module"] = "raw"
+	config["module_args"] = "uptime"
+
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	config["use_runner"] = true
+	p = Provisioner{}
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should error with use_runner")
+	}
+
+	p = Provisioner{}
+	p.config.ModuleArgs = "uptime"
+	if errs := p.prepareModule(); len(errs) == 0 {
+		t.Fatal("should error if module_args is set without module")
+	}
+}
+
+func TestProvisioner_ModuleCommand(t *testing.T) {
+	var p Provisioner
+	p.config.AdhocCommand = "ansible"
+	p.config.inventoryFile = "inventory"
+	p.config.Module = "shell"
+	p.config.ModuleArgs = "systemctl is-active sshd"
+	p.config.ExtraArguments = []string{"--tags", "web", "-v"}
+
+	cmd := p.moduleCommand()
+	expected := []string{"ansible", "all", "-i", "inventory", "-m", "shell", "-a", "systemctl is-active sshd", "-v"}
+	if !reflect.DeepEqual(cmd.Args, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmd.Args)
+	}
+
+	p.config.ModuleArgs = ""
+	cmd = p.moduleCommand()
+	expected = []string{"ansible", "all", "-i", "inventory", "-m", "shell", "-v"}
+	if !reflect.DeepEqual(cmd.Args, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmd.Args)
+	}
+}
+
+func TestProvisioner_ExecuteModuleRedactsSecrets(t *testing.T) {
+	var p Provisioner
+	p.config.AdhocCommand = "packer-provisioner-ansible-missing-ansible"
+	p.config.inventoryFile = "inventory"
+	p.config.Module = "shell"
+	p.config.ModuleArgs = "echo hunter22"
+	p.config.PackerUserVars = map[string]string{"db_password": "hunter22"}
+
+	u := new(messageUi)
+	if err := p.executeModule(newUi(u)); err == nil {
+		t.Fatal("should error if the command cannot be run")
+	}
+	if len(u.says) == 0 {
+		t.Fatal("expected the command to be reported")
+	}
+	for _, s := range u.says {
+		if strings.Contains(s, "hunter22") {
+			t.Fatalf("expected the secret to be redacted: %s", s)
+		}
+	}
+	if !strings.Contains(u.says[0], "echo <sensitive>") {
+		t.Fatalf("unexpected command: %s", u.says[0])
+	}
+}
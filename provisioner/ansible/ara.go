@@ -0,0 +1,57 @@
+package ansible
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// araCallbackName is the name of ARA's callback plugin.
+const araCallbackName = "ara_default"
+
+// araCallbackPluginDir returns the directory of ARA's callback plugins, as
+// reported by the ARA installation of python.
+func araCallbackPluginDir(python string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(python, "-m", "ara.setup.callback_plugins")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// prepareAra locates and validates the directory of ARA's callback plugins.
+func (p *Provisioner) prepareAra() error {
+	if p.config.AraPython == "" {
+		p.config.AraPython = "python3"
+	}
+
+	dir := p.config.AraCallbackPluginPath
+	if dir == "" {
+		var err error
+		dir, err = araCallbackPluginDir(p.config.AraPython)
+		if err != nil {
+			return fmt.Errorf("ara_callback_plugin_path: could not be determined with %s: %s", p.config.AraPython, err)
+		}
+	}
+	if err := validateDirConfig(dir, "ara_callback_plugin_path", true); err != nil {
+		return err
+	}
+
+	p.config.araCallbackPluginDir = dir
+	return nil
+}
+
+// araEnv returns the variables that configure where ARA records the run.
+func (p *Provisioner) araEnv() []string {
+	var env []string
+	if p.config.AraAPIClient != "" {
+		env = append(env, "ARA_API_CLIENT="+p.config.AraAPIClient)
+	}
+	if p.config.AraAPIServer != "" {
+		env = append(env, "ARA_API_SERVER="+p.config.AraAPIServer)
+	}
+	return env
+}
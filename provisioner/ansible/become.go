@@ -0,0 +1,98 @@
+package ansible
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// prepareBecome checks the become options.
+func (p *Provisioner) prepareBecome() []error {
+	var errs []error
+	if p.config.BecomePasswordFile != "" && p.config.BecomePasswordEnv != "" {
+		errs = append(errs, errors.New("become_password_file cannot be used with become_password_env"))
+	}
+	if p.config.BecomePasswordFile != "" {
+		if err := validateFileConfig(p.config.BecomePasswordFile, "become_password_file", true); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if p.config.BecomeMethod == "runas" && p.config.BecomeUser == "" {
+		errs = append(errs, errors.New("become_user must be specified for the runas become_method"))
+	}
+	return errs
+}
+
+// becomeVars returns the inventory variables of the become options.
+func (p *Provisioner) becomeVars() []variable {
+	var vars []variable
+	if p.config.BecomeMethod != "" {
+		vars = append(vars, variable{"ansible_become_method", p.config.BecomeMethod})
+	}
+	if p.config.BecomeUser != "" {
+		vars = append(vars, variable{"ansible_become_user", p.config.BecomeUser})
+	}
+	return vars
+}
+
+// becomePassword returns the become password from become_password_file or
+// become_password_env, and whether either is set.
+func (p *Provisioner) becomePassword() (string, bool, error) {
+	switch {
+	case p.config.BecomePasswordFile != "":
+		b, err := ioutil.ReadFile(p.config.BecomePasswordFile)
+		if err != nil {
+			return "", true, err
+		}
+		return strings.TrimRight(string(b), "\r\n"), true, nil
+	case p.config.BecomePasswordEnv != "":
+		password := os.Getenv(p.config.BecomePasswordEnv)
+		if password == "" {
+			return "", true, fmt.Errorf("%s is not set", p.config.BecomePasswordEnv)
+		}
+		return password, true, nil
+	}
+	return "", false, nil
+}
+
+// writePasswordVars writes the become password and the WinRM password as an
+// extra vars file, which only the current user can read, into the staging
+// directory of the run, so that they are neither in the inventory nor on
+// ansible's command line.
+func (p *Provisioner) writePasswordVars() error {
+	vars := make(map[string]string)
+	password, ok, err := p.becomePassword()
+	if err != nil {
+		return err
+	}
+	if ok {
+		vars["ansible_become_password"] = password
+		vars["ansible_become_pass"] = password
+	}
+	if p.config.Connection == "winrm" && p.config.WinRMPassword != "" {
+		vars["ansible_password"] = p.config.WinRMPassword
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+
+	tf, err := p.tempFile("passwords")
+	if err != nil {
+		return err
+	}
+	p.track(tf.Name())
+	b, _ := json.Marshal(vars)
+	_, err = tf.Write(b)
+	if cerr := tf.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	p.config.passwordVarsFile = tf.Name()
+	return nil
+}
@@ -0,0 +1,67 @@
+package ansible
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestProvisioner_Become(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var p Provisioner
+	p.config.StagingDir = dir
+	p.config.BecomeMethod = "runas"
+	if errs := p.prepareBecome(); len(errs) == 0 {
+		t.Fatal("should error if become_user is missing for runas")
+	}
+
+	p.config.BecomeUser = "Administrator"
+	p.config.BecomePasswordEnv = "PACKER_TEST_BECOME_PASSWORD"
+	if errs := p.prepareBecome(); len(errs) > 0 {
+		t.Fatalf("err: %v", errs)
+	}
+
+	os.Unsetenv("PACKER_TEST_BECOME_PASSWORD")
+	if err := p.writePasswordVars(); err == nil {
+		t.Fatal("should error if the password is not set")
+	}
+
+	os.Setenv("PACKER_TEST_BECOME_PASSWORD", "s3cr3t")
+	defer os.Unsetenv("PACKER_TEST_BECOME_PASSWORD")
+	if err := p.writePasswordVars(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer p.cleanup()
+
+	b, err := ioutil.ReadFile(p.config.passwordVarsFile)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var vars map[string]string
+	if err := json.Unmarshal(b, &vars); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if vars["ansible_become_password"] != "s3cr3t" {
+		t.Fatalf("unexpected become vars: %s", b)
+	}
+	if fi, err := os.Stat(p.config.passwordVarsFile); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected the become vars to be private: %v %v", fi.Mode(), err)
+	}
+
+	args := p.ansibleArgs("playbook.yml", "hosts")
+	found := false
+	for i, arg := range args {
+		if arg == "@"+p.config.passwordVarsFile && i > 0 && args[i-1] == "-e" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected the become vars in %v", args)
+	}
+}
@@ -0,0 +1,50 @@
+package ansible
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// bootstrapPythonCommand installs python3 with the package manager of the
+// machine, with sudo unless it runs as root, unless python is installed.
+const bootstrapPythonCommand = `command -v python3 >/dev/null 2>&1 || command -v python >/dev/null 2>&1 || {
+SUDO=; [ "$(id -u)" = 0 ] || SUDO=sudo
+if command -v apt-get >/dev/null 2>&1; then $SUDO apt-get update && $SUDO env DEBIAN_FRONTEND=noninteractive apt-get install -y python3
+elif command -v dnf >/dev/null 2>&1; then $SUDO dnf install -y python3
+elif command -v yum >/dev/null 2>&1; then $SUDO yum install -y python3
+elif command -v apk >/dev/null 2>&1; then $SUDO apk add --no-cache python3
+elif command -v zypper >/dev/null 2>&1; then $SUDO zypper --non-interactive install python3
+elif command -v pacman >/dev/null 2>&1; then $SUDO pacman -Sy --noconfirm python
+else echo "no supported package manager to install python3" >&2; exit 1
+fi
+}`
+
+// prepareBootstrap checks the bootstrap options.
+func (p *Provisioner) prepareBootstrap() []error {
+	var errs []error
+	if p.config.BootstrapPython && p.config.BootstrapCommand != "" {
+		errs = append(errs, errors.New("bootstrap_python cannot be used with bootstrap_command"))
+	}
+	if p.config.BootstrapPython && p.config.GuestOSType == "windows" {
+		errs = append(errs, errors.New("bootstrap_python cannot be used with the windows guest_os_type"))
+	}
+	return errs
+}
+
+// bootstrap runs bootstrap_command, or installs python when bootstrap_python
+// is set, on the machine through the communicator, before ansible runs.
+func (p *Provisioner) bootstrap(ui packer.Ui, comm packer.Communicator) error {
+	command := p.config.BootstrapCommand
+	if p.config.BootstrapPython {
+		command = bootstrapPythonCommand
+		ui.Say("Bootstrapping Python on the machine")
+	} else {
+		ui.Say(fmt.Sprintf("Bootstrapping the machine: %s", command))
+	}
+	if err := p.runRemote(ui, comm, command); err != nil {
+		return fmt.Errorf("Error bootstrapping the machine: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package ansible
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestBootstrapPythonCommand(t *testing.T) {
+	if out, err := exec.Command("/bin/sh", "-n", "-c", bootstrapPythonCommand).CombinedOutput(); err != nil {
+		t.Fatalf("invalid shell command: %s: %s", err, out)
+	}
+}
+
+func TestProvisioner_PrepareBootstrap(t *testing.T) {
+	var p Provisioner
+	p.config.BootstrapPython = true
+	if errs := p.prepareBootstrap(); len(errs) > 0 {
+		t.Fatalf("err: %v", errs)
+	}
+
+	p.config.BootstrapCommand = "apk add python3"
+	p.config.GuestOSType = "windows"
+	if errs := p.prepareBootstrap(); len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+}
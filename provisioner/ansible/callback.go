@@ -0,0 +1,374 @@
+package ansible
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// callbackPluginName is the name of the bundled stdout callback plugin.
+const callbackPluginName = "packer_json"
+
+// callbackPlugin is the source of the bundled stdout callback plugin. It
+// writes each event as a single line of JSON to stdout.
+const callbackPlugin = `# Generated by packer-provisioner-ansible.
+from __future__ import (absolute_import, division, print_function)
+__metaclass__ = type
+
+import json
+import sys
+import time
+
+from ansible.plugins.callback import CallbackBase
+
+try:
+    string_types = (basestring,)
+except NameError:
+    string_types = (str,)
+
+
+class CallbackModule(CallbackBase):
+    CALLBACK_VERSION = 2.0
+    CALLBACK_TYPE = 'stdout'
+    CALLBACK_NAME = 'packer_json'
+
+    def _emit(self, event, **kwargs):
+        kwargs['event'] = event
+        kwargs['time'] = time.time()
+        sys.stdout.write(json.dumps(kwargs) + '\n')
+        sys.stdout.flush()
+
+    def _text(self, value):
+        if value is None:
+            return ''
+        if isinstance(value, string_types):
+            return value
+        return json.dumps(value)
+
+    def _result(self, status, result, **kwargs):
+        r = result._result
+        self._emit('task_result',
+                   status=status,
+                   host=result._host.get_name(),
+                   task=result._task.get_name().strip(),
+                   action=result._task.action,
+                   changed=bool(r.get('changed', False)),
+                   msg=self._text(r.get('msg')),
+                   stderr=self._text(r.get('stderr')),
+                   **kwargs)
+
+    def v2_playbook_on_start(self, playbook):
+        self._emit('playbook_start', playbook=playbook._file_name)
+
+    def _count_tasks(self, blocks):
+        count = 0
+        for b in blocks:
+            for task in getattr(b, 'block', []):
+                if hasattr(task, 'block'):
+                    count += self._count_tasks([task])
+                elif task.action != 'meta':
+                    count += 1
+        return count
+
+    def v2_playbook_on_play_start(self, play):
+        try:
+            tasks = self._count_tasks(play.compile())
+        except Exception:
+            tasks = 0
+        self._emit('play_start', play=play.get_name().strip(), tasks=tasks)
+
+    def v2_playbook_on_task_start(self, task, is_conditional):
+        self._emit('task_start', task=task.get_name().strip(), action=task.action)
+
+    def v2_playbook_on_handler_task_start(self, task):
+        self._emit('task_start', task=task.get_name().strip(), action=task.action, handler=True)
+
+    def v2_runner_on_ok(self, result):
+        self._result('ok', result)
+
+    def v2_runner_on_failed(self, result, ignore_errors=False):
+        self._result('failed', result, ignore_errors=ignore_errors)
+
+    def v2_runner_on_skipped(self, result):
+        self._result('skipped', result)
+
+    def v2_runner_on_unreachable(self, result):
+        self._result('unreachable', result)
+
+    def v2_playbook_on_stats(self, stats):
+        hosts = sorted(stats.processed.keys())
+        self._emit('stats', stats=dict((h, stats.summarize(h)) for h in hosts))
+`
+
+// writeCallbackPlugin writes the bundled callback plugin into dir.
+func writeCallbackPlugin(dir string) error {
+	return ioutil.WriteFile(filepath.Join(dir, callbackPluginName+".py"), []byte(callbackPlugin), 0644)
+}
+
+// event is a line of output from the bundled callback plugin.
+type event struct {
+	Event        string               `json:"event"`
+	Time         float64              `json:"time"`
+	Playbook     string               `json:"playbook"`
+	Play         string               `json:"play"`
+	Task         string               `json:"task"`
+	Action       string               `json:"action"`
+	Tasks        int                  `json:"tasks"`
+	Handler      bool                 `json:"handler"`
+	Host         string               `json:"host"`
+	Status       string               `json:"status"`
+	Changed      bool                 `json:"changed"`
+	Msg          string               `json:"msg"`
+	Stderr       string               `json:"stderr"`
+	IgnoreErrors bool                 `json:"ignore_errors"`
+	Stats        map[string]hostStats `json:"stats"`
+}
+
+func (e *event) time() time.Time {
+	sec := int64(e.Time)
+	return time.Unix(sec, int64((e.Time-float64(sec))*1e9))
+}
+
+// hostStats are the totals of a host's task results, as reported in the play
+// recap.
+type hostStats struct {
+	Ok          int `json:"ok"`
+	Changed     int `json:"changed"`
+	Failures    int `json:"failures"`
+	Unreachable int `json:"unreachable"`
+	Skipped     int `json:"skipped"`
+}
+
+func (s hostStats) add(o hostStats) hostStats {
+	return hostStats{
+		Ok:          s.Ok + o.Ok,
+		Changed:     s.Changed + o.Changed,
+		Failures:    s.Failures + o.Failures,
+		Unreachable: s.Unreachable + o.Unreachable,
+		Skipped:     s.Skipped + o.Skipped,
+	}
+}
+
+// taskRecord is a task that was started during the run, and its results.
+type taskRecord struct {
+	Playbook string
+	Play     string
+	Name     string
+	Action   string
+	Start    time.Time
+	End      time.Time
+	Results  []taskResult
+}
+
+func (t *taskRecord) Duration() time.Duration {
+	if t.End.IsZero() {
+		return 0
+	}
+	return t.End.Sub(t.Start)
+}
+
+// taskResult is the result of a task on a host.
+type taskResult struct {
+	Host         string
+	Status       string
+	Changed      bool
+	Msg          string
+	Stderr       string
+	IgnoreErrors bool
+}
+
+// eventHandler relays ansible's output to a packer.Ui, rendering the events
+// emitted by the bundled callback plugin, and records the tasks and recap of
+// the run.
+type eventHandler struct {
+	ui packer.Ui
+
+	// mu serializes the handling of the lines of stdout and stderr, which are
+	// read by separate goroutines.
+	mu sync.Mutex
+
+	playbook string
+	play     string
+	tasks    []*taskRecord
+	stats    map[string]hostStats // accumulated over the recap of each playbook
+	inRecap  bool
+
+	warnings     []string
+	deprecations []string
+
+	// noColor removes ANSI escape sequences from the output.
+	noColor bool
+
+	// quiet suppresses all output but failures, warnings, and the recap.
+	quiet bool
+
+	// progress enables reporting the position of each task in its play.
+	progress  bool
+	playTasks int // the number of tasks in the play, when known
+	playTask  int // the number of tasks started in the play
+}
+
+func newEventHandler(ui packer.Ui) *eventHandler {
+	return &eventHandler{ui: ui, stats: make(map[string]hostStats)}
+}
+
+// Line handles a line of ansible's stdout. Lines that are not events are
+// relayed to the ui.
+func (h *eventHandler) Line(line string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var e event
+	if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &e) != nil || e.Event == "" {
+		h.relay(line)
+		return
+	}
+	h.handle(&e)
+}
+
+func (h *eventHandler) handle(e *event) {
+	switch e.Event {
+	case "playbook_start":
+		h.playbook = e.Playbook
+		if !h.quiet {
+			h.ui.Message(fmt.Sprintf("PLAYBOOK [%s]", e.Playbook))
+		}
+
+	case "play_start":
+		h.startPlay(e.Play, e.Tasks)
+		if !h.quiet {
+			h.ui.Message(fmt.Sprintf("PLAY [%s]", e.Play))
+		}
+
+	case "task_start":
+		h.tasks = append(h.tasks, &taskRecord{
+			Playbook: h.playbook,
+			Play:     h.play,
+			Name:     e.Task,
+			Action:   e.Action,
+			Start:    e.time(),
+		})
+		if !e.Handler {
+			h.startTask(e.Task)
+		}
+
+	case "task_result":
+		result := taskResult{
+			Host:         e.Host,
+			Status:       e.Status,
+			Changed:      e.Changed,
+			Msg:          e.Msg,
+			Stderr:       e.Stderr,
+			IgnoreErrors: e.IgnoreErrors,
+		}
+		var duration time.Duration
+		if task := h.task(); task != nil {
+			task.End = e.time()
+			task.Results = append(task.Results, result)
+			duration = task.Duration()
+		}
+		h.machineResult(e.Task, result)
+		h.renderResult(e.Task, duration, result)
+
+	case "stats":
+		hosts := make([]string, 0, len(e.Stats))
+		for host, stats := range e.Stats {
+			h.stats[host] = h.stats[host].add(stats)
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+
+		h.ui.Message("PLAY RECAP")
+		for _, host := range hosts {
+			s := e.Stats[host]
+			h.ui.Message(fmt.Sprintf("    %s: ok=%d changed=%d unreachable=%d failed=%d skipped=%d",
+				host, s.Ok, s.Changed, s.Unreachable, s.Failures, s.Skipped))
+		}
+	}
+}
+
+func (h *eventHandler) startPlay(play string, tasks int) {
+	h.play = play
+	h.playTasks = tasks
+	h.playTask = 0
+}
+
+// startTask reports the start of a task as a machine-readable event and, when
+// progress is enabled, reports the progress through the play.
+func (h *eventHandler) startTask(name string) {
+	h.ui.Machine("ansible-task-start", h.play, name)
+	h.playTask++
+	if !h.progress {
+		return
+	}
+	if h.playTasks > 0 {
+		h.ui.Say(fmt.Sprintf("task %d/%d: %s", h.playTask, h.playTasks, name))
+	} else {
+		h.ui.Say(fmt.Sprintf("task %d: %s", h.playTask, name))
+	}
+}
+
+func (h *eventHandler) machineResult(task string, r taskResult) {
+	h.ui.Machine("ansible-task-result", r.Host, task, r.Status, strconv.FormatBool(r.Changed))
+}
+
+// machineRecap reports the recap of each host as machine-readable events.
+func (h *eventHandler) machineRecap() {
+	hosts := make([]string, 0, len(h.stats))
+	for host := range h.stats {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	for _, host := range hosts {
+		s := h.stats[host]
+		h.ui.Machine("ansible-recap", host,
+			strconv.Itoa(s.Ok), strconv.Itoa(s.Changed), strconv.Itoa(s.Unreachable),
+			strconv.Itoa(s.Failures), strconv.Itoa(s.Skipped))
+	}
+}
+
+// task returns the task that was started last.
+func (h *eventHandler) task() *taskRecord {
+	if len(h.tasks) == 0 {
+		return nil
+	}
+	return h.tasks[len(h.tasks)-1]
+}
+
+func (h *eventHandler) renderResult(task string, duration time.Duration, r taskResult) {
+	status := r.Status
+	if status == "ok" && r.Changed {
+		status = "changed"
+	}
+	line := fmt.Sprintf("%s: [%s] %s (%s)", status, r.Host, task, duration)
+
+	switch r.Status {
+	case "failed", "unreachable":
+		if r.IgnoreErrors {
+			if !h.quiet {
+				h.ui.Message(line + " (ignored)")
+			}
+			return
+		}
+		h.ui.Error(line)
+		if r.Msg != "" {
+			h.ui.Error("    " + r.Msg)
+		}
+		if r.Stderr != "" {
+			h.ui.Error("    " + r.Stderr)
+		}
+	default:
+		if !h.quiet {
+			h.ui.Message(line)
+		}
+	}
+}
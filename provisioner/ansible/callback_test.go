@@ -0,0 +1,91 @@
+package ansible
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventHandler_Line(t *testing.T) {
+	h := newEventHandler(newUi(new(ui)))
+
+	for _, line := range []string{
+		`{"event": "playbook_start", "time": 100.0, "playbook": "playbook.yml"}`,
+		`{"event": "play_start", "time": 100.0, "play": "all"}`,
+		`{"event": "task_start", "time": 100.0, "task": "install packages", "action": "apt"}`,
+		`not an event`,
+		`{"event": "task_result", "time": 102.5, "task": "install packages", "host": "default", "status": "ok", "changed": true}`,
+		`{"event": "task_start", "time": 102.5, "task": "start service", "action": "service"}`,
+		`{"event": "task_result", "time": 103.0, "task": "start service", "host": "default", "status": "failed", "msg": "no such service"}`,
+		`{"event": "stats", "time": 103.0, "stats": {"default": {"ok": 1, "changed": 1, "failures": 1, "unreachable": 0, "skipped": 0}}}`,
+	} {
+		h.Line(line)
+	}
+
+	if len(h.tasks) != 2 {
+		t.Fatalf("expected 2 tasks, got %d", len(h.tasks))
+	}
+
+	task := h.tasks[0]
+	if task.Name != "install packages" || task.Play != "all" || task.Playbook != "playbook.yml" {
+		t.Fatalf("unexpected task: %+v", task)
+	}
+	if d := task.Duration(); d != 2500*time.Millisecond {
+		t.Fatalf("expected a duration of 2.5s, got %s", d)
+	}
+	if len(task.Results) != 1 || !task.Results[0].Changed {
+		t.Fatalf("unexpected results: %+v", task.Results)
+	}
+
+	task = h.tasks[1]
+	if len(task.Results) != 1 || task.Results[0].Status != "failed" || task.Results[0].Msg != "no such service" {
+		t.Fatalf("unexpected results: %+v", task.Results)
+	}
+
+	if s, ok := h.stats["default"]; !ok || s.Failures != 1 || s.Ok != 1 {
+		t.Fatalf("unexpected stats: %+v", h.stats)
+	}
+}
+
+func TestEventHandler_Progress(t *testing.T) {
+	u := new(messageUi)
+	h := newEventHandler(u)
+	h.Line(`{"event": "play_start", "time": 100.0, "play": "all", "tasks": 2}`)
+	h.Line(`{"event": "task_start", "time": 100.0, "task": "install packages"}`)
+	if len(u.says) != 0 {
+		t.Fatalf("expected no progress without show_progress, got %v", u.says)
+	}
+
+	h.progress = true
+	h.Line(`{"event": "play_start", "time": 100.0, "play": "all", "tasks": 2}`)
+	h.Line(`{"event": "task_start", "time": 100.0, "task": "install packages"}`)
+	h.Line(`{"event": "task_start", "time": 101.0, "task": "restart sshd", "handler": true}`)
+	h.Line(`{"event": "task_start", "time": 102.0, "task": "start service"}`)
+	expected := []string{"task 1/2: install packages", "task 2/2: start service"}
+	if !reflect.DeepEqual(u.says, expected) {
+		t.Fatalf("expected %v, got %v", expected, u.says)
+	}
+}
+
+func TestEventHandler_Machine(t *testing.T) {
+	u := new(messageUi)
+	h := newEventHandler(u)
+	for _, line := range []string{
+		`{"event": "play_start", "time": 100.0, "play": "all"}`,
+		`{"event": "task_start", "time": 100.0, "task": "install packages"}`,
+		`{"event": "task_result", "time": 102.5, "task": "install packages", "host": "default", "status": "ok", "changed": true}`,
+		`{"event": "stats", "time": 103.0, "stats": {"default": {"ok": 1, "changed": 1, "failures": 0, "unreachable": 0, "skipped": 0}}}`,
+	} {
+		h.Line(line)
+	}
+	h.machineRecap()
+
+	expected := []string{
+		"ansible-task-start,all,install packages",
+		"ansible-task-result,default,install packages,ok,true",
+		"ansible-recap,default,1,1,0,0,0",
+	}
+	if !reflect.DeepEqual(u.machines, expected) {
+		t.Fatalf("expected %v, got %v", expected, u.machines)
+	}
+}
@@ -0,0 +1,71 @@
+package ansible
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"text/template"
+)
+
+// connectionData is the data of the templates in extra_arguments,
+// ansible_env_vars, pre_commands, and post_commands, which refer to the
+// machine as it is reached through the SSH proxy.
+type connectionData struct {
+	Host    string
+	Port    string
+	User    string
+	KeyFile string
+}
+
+// passthroughConnectionData is the data with which Packer interpolates the
+// configuration. It renders the templates of connectionData as themselves, so
+// that they are left to renderConnection, when the proxy is listening.
+var passthroughConnectionData = &connectionData{
+	Host:    "{{ .Host }}",
+	Port:    "{{ .Port }}",
+	User:    "{{ .User }}",
+	KeyFile: "{{ .KeyFile }}",
+}
+
+func (p *Provisioner) connectionData() *connectionData {
+	d := p.inventoryTemplateData()
+	return &connectionData{Host: d.Address, Port: d.Port, User: d.User, KeyFile: d.KeyFile}
+}
+
+// validateConnectionTemplate checks that s is a template of connectionData.
+func validateConnectionTemplate(s string) error {
+	t, err := template.New("").Parse(s)
+	if err != nil {
+		return err
+	}
+	return t.Execute(ioutil.Discard, &connectionData{})
+}
+
+// renderConnection renders s, a template of connectionData, which has been
+// checked by validateConnectionTemplate.
+func (p *Provisioner) renderConnection(s string) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+	t, err := template.New("").Parse(s)
+	if err != nil {
+		return s
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, p.connectionData()); err != nil {
+		return s
+	}
+	return b.String()
+}
+
+// renderConnections renders each of ss with renderConnection.
+func (p *Provisioner) renderConnections(ss []string) []string {
+	if ss == nil {
+		return nil
+	}
+	rendered := make([]string, len(ss))
+	for i, s := range ss {
+		rendered[i] = p.renderConnection(s)
+	}
+	return rendered
+}
@@ -0,0 +1,31 @@
+package ansible
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProvisioner_RenderConnection(t *testing.T) {
+	if err := validateConnectionTemplate("--ssh-extra-args=-p {{ .Port }}"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := validateConnectionTemplate("{{ .Hostname }}"); err == nil {
+		t.Fatal("should error for an unknown field")
+	}
+
+	var p Provisioner
+	p.config.LocalPort = "2222"
+	p.config.SSHPrivateKeyFile = "/key"
+	p.config.ExtraArguments = []string{"-e", "proxy={{ .User }}@{{ .Host }}:{{ .Port }}", "--private-key={{ .KeyFile }}"}
+	p.config.AnsibleEnvVars = []string{"PROXY_PORT={{ .Port }}"}
+
+	expected := []string{"-e", "proxy=packer-ansible@127.0.0.1:2222", "--private-key=/key"}
+	if args := p.renderConnections(p.config.ExtraArguments); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected %v, got %v", expected, args)
+	}
+
+	env := p.env()
+	if env[len(env)-1] != "PROXY_PORT=2222" {
+		t.Fatalf("expected PROXY_PORT=2222 in %v", env)
+	}
+}
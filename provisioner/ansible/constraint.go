@@ -0,0 +1,135 @@
+package ansible
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// versionConstraintOps are the operators of a version constraint, longest
+// first so that they match before their prefixes.
+var versionConstraintOps = []string{">=", "<=", "!=", "~>", ">", "<", "="}
+
+// versionConstraint is a single constraint on a version, e.g. ">= 0.8.0".
+type versionConstraint struct {
+	op      string
+	version []int
+}
+
+// parseVersionConstraints parses comma separated constraints, e.g.
+// ">= 0.8.0, < 0.9".
+func parseVersionConstraints(s string) ([]versionConstraint, error) {
+	var constraints []versionConstraint
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		op := "="
+		for _, o := range versionConstraintOps {
+			if strings.HasPrefix(part, o) {
+				op = o
+				part = strings.TrimSpace(part[len(o):])
+				break
+			}
+		}
+		v, err := parseVersionNumber(part)
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, versionConstraint{op, v})
+	}
+	return constraints, nil
+}
+
+// parseVersionNumber parses the numeric segments of a version, ignoring any
+// pre-release or build suffix, e.g. 0.8.7-dev.
+func parseVersionNumber(s string) ([]int, error) {
+	if i := strings.IndexAny(s, "-+ "); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimPrefix(s, "v")
+	if s == "" {
+		return nil, fmt.Errorf("%q is not a valid version", s)
+	}
+	var v []int
+	for _, segment := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(segment)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%q is not a valid version", s)
+		}
+		v = append(v, n)
+	}
+	return v, nil
+}
+
+// compareVersions returns -1, 0, or 1 when a is less than, equal to, or
+// greater than b. Missing segments are zero.
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// check reports whether v satisfies the constraint. The pessimistic operator
+// ~> allows the last given segment to increase, so "~> 0.8.2" allows 0.8.9
+// but not 0.9.0.
+func (c versionConstraint) check(v []int) bool {
+	cmp := compareVersions(v, c.version)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case "~>":
+		if cmp < 0 {
+			return false
+		}
+		n := len(c.version) - 1
+		if n == 0 {
+			return true
+		}
+		return compareVersions(prefix(v, n), c.version[:n]) == 0
+	}
+	return cmp == 0
+}
+
+func prefix(v []int, n int) []int {
+	p := make([]int, n)
+	copy(p, v)
+	return p
+}
+
+// checkVersionConstraints checks that version satisfies the constraints.
+func checkVersionConstraints(constraints, version string) error {
+	cs, err := parseVersionConstraints(constraints)
+	if err != nil {
+		return err
+	}
+	v, err := parseVersionNumber(version)
+	if err != nil {
+		return err
+	}
+	for _, c := range cs {
+		if !c.check(v) {
+			return fmt.Errorf("%s, which does not satisfy %s", version, constraints)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package ansible
+
+import "testing"
+
+func TestCheckVersionConstraints(t *testing.T) {
+	for _, tc := range []struct {
+		constraints string
+		version     string
+		ok          bool
+	}{
+		{">= 0.8.0", "0.8.7-dev", true},
+		{">= 0.8.0, < 0.8.6", "0.8.7", false},
+		{"0.8.7", "0.8.7", true},
+		{"!= 0.8.7", "0.8.7", false},
+		{"~> 0.8.2", "0.8.9", true},
+		{"~> 0.8.2", "0.9.0", false},
+		{"~> 0.8", "0.9.1", true},
+		{"~> 0.8", "1.0.0", false},
+		{"> 0.8", "0.8.0", false},
+	} {
+		err := checkVersionConstraints(tc.constraints, tc.version)
+		if (err == nil) != tc.ok {
+			t.Fatalf("unexpected result for %s against %s: %v", tc.version, tc.constraints, err)
+		}
+	}
+
+	if err := checkVersionConstraints(">= x", "0.8.7"); err == nil {
+		t.Fatal("should error for an invalid constraint")
+	}
+}
@@ -0,0 +1,137 @@
+package ansible
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// containerConnections are the connections with which ansible reaches the
+// machine, a container, by its name instead of through the SSH proxy.
+var containerConnections = map[string]bool{
+	"docker":  true,
+	"podman":  true,
+	"lxd":     true,
+	"lxc":     true,
+	"chroot":  true,
+	"kubectl": true,
+}
+
+// builderConnections are the connections that are used by default for the
+// containers of builders.
+var builderConnections = map[string]string{
+	"docker": "docker",
+	"podman": "podman",
+	"lxd":    "lxd",
+	"lxc":    "lxc",
+}
+
+// isContainerConnection reports whether the connection is one of
+// containerConnections.
+func (p *Provisioner) isContainerConnection() bool {
+	return containerConnections[p.config.Connection]
+}
+
+// prepareContainer checks the options of a container connection.
+func (p *Provisioner) prepareContainer() []error {
+	var errs []error
+	if p.config.Connection == "chroot" {
+		if err := validateDirConfig(p.config.ChrootPath, "chroot_path", true); err != nil {
+			errs = append(errs, err)
+		}
+		// The chroot connection resolves a relative path against ansible's
+		// working directory.
+		p.config.ChrootPath, _ = filepath.Abs(p.config.ChrootPath)
+	}
+	if p.config.Connection == "kubectl" && p.config.KubectlKubeconfig != "" {
+		if err := validateFileConfig(p.config.KubectlKubeconfig, "kubectl_kubeconfig", true); err != nil {
+			errs = append(errs, err)
+		}
+		// ansible runs in working_directory, if set, rather than where Packer does.
+		p.config.KubectlKubeconfig, _ = filepath.Abs(p.config.KubectlKubeconfig)
+	}
+	if p.config.UseProxy != nil && *p.config.UseProxy {
+		errs = append(errs, fmt.Errorf("use_proxy cannot be used with the %s connection", p.config.Connection))
+	}
+	if p.config.GenerateSSHConfig {
+		errs = append(errs, fmt.Errorf("generate_ssh_config cannot be used with the %s connection", p.config.Connection))
+	}
+	return errs
+}
+
+// discoverContainer sets the container that ansible connects to: chroot_path
+// for the chroot connection, kubectl_pod for the kubectl connection,
+// container, or else the hostname of the machine, which is the short ID of a
+// docker or podman container, the name of an lxd or lxc container, and the
+// name of a pod, unless the builder set another.
+func (p *Provisioner) discoverContainer(ui packer.Ui, comm packer.Communicator) error {
+	if p.config.Connection == "chroot" {
+		p.config.container = p.config.ChrootPath
+		return nil
+	}
+	name := p.config.Container
+	if p.config.Connection == "kubectl" {
+		name = p.config.KubectlPod
+	}
+	if name != "" {
+		p.config.container = name
+		return nil
+	}
+
+	var stdout bytes.Buffer
+	cmd := &packer.RemoteCmd{Command: "cat /etc/hostname", Stdout: &stdout}
+	if err := comm.Start(cmd); err != nil {
+		return err
+	}
+	cmd.Wait()
+	if cmd.ExitStatus != 0 {
+		return fmt.Errorf("non-zero exit status: %d", cmd.ExitStatus)
+	}
+	name = strings.TrimSpace(stdout.String())
+	if name == "" {
+		return errors.New("the machine has no hostname; set container")
+	}
+
+	p.config.container = name
+	return nil
+}
+
+// containerVars returns the variables with which ansible connects to the
+// container.
+func (p *Provisioner) containerVars() []variable {
+	if p.config.Connection == "kubectl" {
+		return p.kubectlVars()
+	}
+
+	vars := []variable{
+		{"ansible_connection", p.config.Connection},
+		{"ansible_host", p.config.container},
+	}
+	if p.config.InventoryUser != "" {
+		vars = append(vars, variable{"ansible_user", p.config.InventoryUser})
+	}
+	return vars
+}
+
+// kubectlVars returns the variables with which the kubernetes.core.kubectl
+// connection plugin connects to the pod.
+func (p *Provisioner) kubectlVars() []variable {
+	vars := []variable{
+		{"ansible_connection", "kubernetes.core.kubectl"},
+		{"ansible_kubectl_pod", p.config.container},
+	}
+	if p.config.KubectlNamespace != "" {
+		vars = append(vars, variable{"ansible_kubectl_namespace", p.config.KubectlNamespace})
+	}
+	if p.config.KubectlKubeconfig != "" {
+		vars = append(vars, variable{"ansible_kubectl_kubeconfig", p.controllerPath(p.config.KubectlKubeconfig)})
+	}
+	if p.config.KubectlContainer != "" {
+		vars = append(vars, variable{"ansible_kubectl_container", p.config.KubectlContainer})
+	}
+	return vars
+}
@@ -0,0 +1,199 @@
+package ansible
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// hostnameCommunicator writes a hostname to the stdout of each command.
+type hostnameCommunicator struct {
+	communicator
+	hostname string
+}
+
+func (c hostnameCommunicator) Start(cmd *packer.RemoteCmd) error {
+	io.WriteString(cmd.Stdout, c.hostname+"\n")
+	cmd.SetExited(0)
+	return nil
+}
+
+func TestProvisioner_Container(t *testing.T) {
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.Connection = "docker"
+
+	if p.useProxy() {
+		t.Fatal("expected the docker connection not to use the proxy")
+	}
+	if err := p.discoverContainer(new(ui), hostnameCommunicator{hostname: "0123456789ab"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var b bytes.Buffer
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := "default ansible_connection=docker ansible_host=0123456789ab\n"
+	if b.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, b.String())
+	}
+
+	p.config.Container = "build"
+	if err := p.discoverContainer(new(ui), communicator{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if p.config.container != "build" {
+		t.Fatalf("expected the container to be build, got %s", p.config.container)
+	}
+}
+
+func TestProvisioner_Podman(t *testing.T) {
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.Connection = "podman"
+	p.config.Container = "build"
+
+	if !p.isContainerConnection() {
+		t.Fatal("expected podman to be a container connection")
+	}
+	if err := p.discoverContainer(new(ui), communicator{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var b bytes.Buffer
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := "default ansible_connection=podman ansible_host=build\n"
+	if b.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, b.String())
+	}
+}
+
+func TestProvisionerPrepare_BuilderConnection(t *testing.T) {
+	for builder, connection := range map[string]string{
+		"docker":     "docker",
+		"lxd":        "lxd",
+		"lxc":        "lxc",
+		"virtualbox": "ssh",
+	} {
+		var p Provisioner
+		p.Prepare(map[string]interface{}{"packer_builder_type": builder})
+		if p.config.Connection != connection {
+			t.Errorf("%s: expected the %s connection, got %s", builder, connection, p.config.Connection)
+		}
+	}
+}
+
+func TestProvisioner_Chroot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.Connection = "chroot"
+	if errs := p.prepareContainer(); len(errs) == 0 {
+		t.Fatal("should error if chroot_path is missing")
+	}
+
+	p.config.ChrootPath = dir
+	if errs := p.prepareContainer(); len(errs) > 0 {
+		t.Fatalf("err: %v", errs)
+	}
+	if err := p.discoverContainer(new(ui), communicator{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var b bytes.Buffer
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := "default ansible_connection=chroot ansible_host=" + dir + "\n"
+	if b.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, b.String())
+	}
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	if err := os.Chdir(filepath.Dir(dir)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	p.config.ChrootPath = filepath.Base(dir)
+	if errs := p.prepareContainer(); len(errs) > 0 {
+		t.Fatalf("err: %v", errs)
+	}
+	if err := p.discoverContainer(new(ui), communicator{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if p.config.container != dir {
+		t.Fatalf("expected chroot_path to be made absolute, got %s", p.config.container)
+	}
+}
+
+func TestProvisioner_Kubectl(t *testing.T) {
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.Connection = "kubectl"
+	p.config.KubectlNamespace = "builds"
+
+	if errs := p.prepareContainer(); len(errs) > 0 {
+		t.Fatalf("err: %v", errs)
+	}
+	if err := p.discoverContainer(new(ui), hostnameCommunicator{hostname: "build-7f9c"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var b bytes.Buffer
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := "default ansible_connection=kubernetes.core.kubectl ansible_kubectl_pod=build-7f9c ansible_kubectl_namespace=builds\n"
+	if b.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, b.String())
+	}
+
+	p.config.KubectlPod = "build"
+	if err := p.discoverContainer(new(ui), communicator{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if p.config.container != "build" {
+		t.Fatalf("expected the pod to be build, got %s", p.config.container)
+	}
+
+	p.config.KubectlKubeconfig = "/nonexistent/kubeconfig"
+	if errs := p.prepareContainer(); len(errs) == 0 {
+		t.Fatal("should error if kubectl_kubeconfig does not exist")
+	}
+
+	kubeconfig, err := ioutil.TempFile(".", "kubeconfig")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	kubeconfig.Close()
+	defer os.Remove(kubeconfig.Name())
+	p.config.KubectlKubeconfig = filepath.Base(kubeconfig.Name())
+	if errs := p.prepareContainer(); len(errs) > 0 {
+		t.Fatalf("err: %v", errs)
+	}
+	abs, _ := filepath.Abs(kubeconfig.Name())
+	if p.config.KubectlKubeconfig != abs {
+		t.Fatalf("expected kubectl_kubeconfig to be made absolute, got %s", p.config.KubectlKubeconfig)
+	}
+	b.Reset()
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(b.String(), " ansible_kubectl_kubeconfig="+abs) {
+		t.Fatalf("unexpected inventory %q", b.String())
+	}
+}
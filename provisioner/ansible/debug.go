@@ -0,0 +1,66 @@
+package ansible
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// validateDebugAddress checks that addr is a loopback address, so that the
+// debug endpoint is not exposed to the network.
+func validateDebugAddress(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("pprof_address: %s", err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("pprof_address: %s must be a loopback address", addr)
+	}
+	return nil
+}
+
+// debugHandler serves pprof profiles, expvar variables, and the statistics of
+// the SSH proxy.
+func (p *Provisioner) debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/proxy", func(w http.ResponseWriter, r *http.Request) {
+		if p.adapter == nil {
+			http.NotFound(w, r)
+			return
+		}
+		connections, sessions, commands := p.adapter.Stats()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]uint64{
+			"connections": connections,
+			"sessions":    sessions,
+			"commands":    commands,
+		})
+	})
+	return mux
+}
+
+// serveDebug serves the debug endpoint at pprof_address until the returned
+// listener is closed.
+func (p *Provisioner) serveDebug(ui packer.Ui) (net.Listener, error) {
+	l, err := net.Listen("tcp", p.config.PprofAddress)
+	if err != nil {
+		return nil, fmt.Errorf("Error serving the debug endpoint: %s", err)
+	}
+	ui.Say(fmt.Sprintf("Serving pprof and expvar at http://%s/debug/", l.Addr()))
+	go http.Serve(l, p.debugHandler())
+	return l, nil
+}
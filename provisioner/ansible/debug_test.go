@@ -0,0 +1,18 @@
+package ansible
+
+import "testing"
+
+func TestValidateDebugAddress(t *testing.T) {
+	for addr, valid := range map[string]bool{
+		"127.0.0.1:6060": true,
+		"localhost:6060": true,
+		"[::1]:6060":     true,
+		"0.0.0.0:6060":   false,
+		"10.0.0.1:6060":  false,
+		"127.0.0.1":      false,
+	} {
+		if err := validateDebugAddress(addr); (err == nil) != valid {
+			t.Fatalf("unexpected result for %s: %v", addr, err)
+		}
+	}
+}
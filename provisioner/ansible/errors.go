@@ -0,0 +1,154 @@
+package ansible
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// The kinds of failure of an AnsibleError.
+const (
+	// FailureFailed is a run in which tasks failed, exit status 2.
+	FailureFailed = "failed"
+	// FailureUnreachable is a run in which ansible could not connect to the
+	// machine, exit status 3, or 4 with unreachable hosts.
+	FailureUnreachable = "unreachable"
+	// FailureParse is a run that failed to parse the playbooks, the
+	// inventory, or the variables, exit status 4 without unreachable hosts.
+	FailureParse = "parse"
+	// FailureOptions is a run with bad or incomplete options, exit status 5.
+	FailureOptions = "options"
+	// FailureInterrupted is a run that was interrupted, exit status 99, or
+	// that was killed by a signal.
+	FailureInterrupted = "interrupted"
+	// FailureUnexpected is a run that failed with an unexpected error, exit
+	// status 250.
+	FailureUnexpected = "unexpected"
+	// FailureError is any other failure.
+	FailureError = "error"
+)
+
+// failureMessages describe the kinds of failure.
+var failureMessages = map[string]string{
+	FailureFailed:      "Ansible tasks failed",
+	FailureUnreachable: "Ansible could not reach the machine",
+	FailureParse:       "Ansible could not parse the playbooks, the inventory, or the variables",
+	FailureOptions:     "Ansible was given bad or incomplete options",
+	FailureInterrupted: "Ansible was interrupted",
+	FailureUnexpected:  "Ansible failed unexpectedly",
+	FailureError:       "Ansible failed",
+}
+
+// AnsibleError is the error of an ansible command that exited with a
+// non-zero status. Kind tells connection problems apart from failed tasks, so
+// that automation that runs Packer can react to them differently.
+type AnsibleError struct {
+	// Kind is one of the Failure constants.
+	Kind string
+
+	// ExitStatus is the exit status of the command, or -1 when it was killed
+	// by a signal.
+	ExitStatus int
+
+	// Failures describe the tasks that failed, if any.
+	Failures []string
+
+	// Err is the error of the command.
+	Err error
+}
+
+func (e *AnsibleError) Error() string {
+	s := failureMessages[e.Kind]
+	if len(e.Failures) > 0 {
+		s += ": " + strings.Join(e.Failures, "; ")
+	}
+	return fmt.Sprintf("%s (%s)", s, e.Err)
+}
+
+// report displays the kind of failure, and reports it as the
+// ansible-failure event with the exit status.
+func (e *AnsibleError) report(ui packer.Ui) {
+	ui.Error(fmt.Sprintf("%s (%s)", failureMessages[e.Kind], e.Kind))
+	ui.Machine("ansible-failure", e.Kind, strconv.Itoa(e.ExitStatus))
+}
+
+// newAnsibleError returns the error of an ansible command that exited with
+// status, or that was killed by a signal, with err. The failed tasks and the
+// unreachable hosts are taken from events, unless it is nil. For the commands
+// without events, unreachable tells whether the output reported an
+// unreachable host.
+func newAnsibleError(status int, signaled bool, events *eventHandler, unreachable bool, err error) *AnsibleError {
+	e := &AnsibleError{ExitStatus: status, Err: err}
+	if events != nil {
+		e.Failures = events.failures()
+		unreachable = unreachable || events.totals().Unreachable > 0
+	}
+
+	switch {
+	case signaled || status == 99:
+		e.Kind = FailureInterrupted
+	case status == 2:
+		e.Kind = FailureFailed
+	case status == 3, status == 4 && unreachable:
+		e.Kind = FailureUnreachable
+	case status == 4:
+		e.Kind = FailureParse
+	case status == 5:
+		e.Kind = FailureOptions
+	case status == 250:
+		e.Kind = FailureUnexpected
+	default:
+		e.Kind = FailureError
+	}
+	return e
+}
+
+// unreachableUi is a packer.Ui that notes whether the output of ansible that
+// it displays reports an unreachable host, for the commands that have no
+// events from which to tell unreachable hosts apart from parse errors.
+type unreachableUi struct {
+	packer.Ui
+	seen int32
+}
+
+func (u *unreachableUi) Message(s string) {
+	u.note(s)
+	u.Ui.Message(s)
+}
+
+func (u *unreachableUi) Error(s string) {
+	u.note(s)
+	u.Ui.Error(s)
+}
+
+func (u *unreachableUi) note(s string) {
+	// e.g. "default | UNREACHABLE! => {" or "fatal: [default]: UNREACHABLE! =>"
+	if strings.Contains(s, "UNREACHABLE!") {
+		atomic.StoreInt32(&u.seen, 1)
+	}
+}
+
+// unreachable reports whether a host was reported unreachable.
+func (u *unreachableUi) unreachable() bool {
+	return atomic.LoadInt32(&u.seen) == 1
+}
+
+// exitStatus returns the exit status of the command that returned err, and
+// whether it was killed by a signal. ok is false when err is not the error of
+// a command that exited.
+func exitStatus(err error) (status int, signaled, ok bool) {
+	exitErr, isExit := err.(*exec.ExitError)
+	if !isExit {
+		return 0, false, false
+	}
+	ws, isWait := exitErr.Sys().(syscall.WaitStatus)
+	if !isWait {
+		return 1, false, true
+	}
+	return ws.ExitStatus(), ws.Signaled(), true
+}
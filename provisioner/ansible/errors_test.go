@@ -0,0 +1,78 @@
+package ansible
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestNewAnsibleError(t *testing.T) {
+	unreachable := newEventHandler(newUi(new(ui)))
+	unreachable.stats = map[string]hostStats{"default": {Unreachable: 1}}
+
+	for _, tc := range []struct {
+		status      int
+		signaled    bool
+		events      *eventHandler
+		unreachable bool
+		kind        string
+	}{
+		{1, false, nil, false, FailureError},
+		{2, false, nil, false, FailureFailed},
+		{3, false, nil, false, FailureUnreachable},
+		{4, false, unreachable, false, FailureUnreachable},
+		{4, false, nil, true, FailureUnreachable},
+		{4, false, nil, false, FailureParse},
+		{4, false, newEventHandler(newUi(new(ui))), false, FailureParse},
+		{5, false, nil, false, FailureOptions},
+		{99, false, nil, false, FailureInterrupted},
+		{-1, true, nil, false, FailureInterrupted},
+		{250, false, nil, false, FailureUnexpected},
+	} {
+		err := newAnsibleError(tc.status, tc.signaled, tc.events, tc.unreachable, errors.New("exit status"))
+		if err.Kind != tc.kind {
+			t.Errorf("%d: expected %s, got %s", tc.status, tc.kind, err.Kind)
+		}
+	}
+}
+
+func TestAnsibleError_Error(t *testing.T) {
+	err := &AnsibleError{
+		Kind:       FailureFailed,
+		ExitStatus: 2,
+		Failures:   []string{`task "install packages" failed on default`},
+		Err:        errors.New("exit status 2"),
+	}
+	expected := `Ansible tasks failed: task "install packages" failed on default (exit status 2)`
+	if err.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	err := exec.Command("sh", "-c", "exit 4").Run()
+	if status, signaled, ok := exitStatus(err); !ok || signaled || status != 4 {
+		t.Fatalf("expected exit status 4, got %d (signaled %t, ok %t)", status, signaled, ok)
+	}
+
+	err = exec.Command("sh", "-c", "kill -INT $$").Run()
+	if _, signaled, ok := exitStatus(err); !ok || !signaled {
+		t.Fatalf("expected an interrupted command, got %v", err)
+	}
+
+	if _, _, ok := exitStatus(errors.New("not an exit")); ok {
+		t.Fatal("expected no exit status")
+	}
+}
+
+func TestUnreachableUi(t *testing.T) {
+	output := &unreachableUi{Ui: new(ui)}
+	output.Message("default | SUCCESS => {")
+	if output.unreachable() {
+		t.Fatal("expected no unreachable host")
+	}
+	output.Error(`default | UNREACHABLE! => {"changed": false, "unreachable": true}`)
+	if !output.unreachable() {
+		t.Fatal("expected an unreachable host")
+	}
+}
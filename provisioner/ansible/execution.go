@@ -0,0 +1,162 @@
+package ansible
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// prepareExecutionContainer checks the execution_container options.
+func (p *Provisioner) prepareExecutionContainer() []error {
+	var errs []error
+	if p.config.ExecutionContainer == "" {
+		return nil
+	}
+	switch p.config.ExecutionContainerEngine {
+	case "":
+		p.config.ExecutionContainerEngine = "docker"
+	case "docker", "podman":
+	default:
+		errs = append(errs, fmt.Errorf("execution_container_engine: %s must be one of docker or podman", p.config.ExecutionContainerEngine))
+	}
+	if p.config.ExecutionContainerNetwork == "" {
+		p.config.ExecutionContainerNetwork = "host"
+	}
+	for _, volume := range p.config.ExecutionContainerVolumes {
+		if parts := strings.Split(volume, ":"); len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			errs = append(errs, fmt.Errorf("execution_container_volumes: %s must be of the form source:destination[:options]", volume))
+		}
+	}
+	return errs
+}
+
+// command returns the command that runs the ansible command name with args in
+// dir, on the local machine, in a container of the execution_container image,
+// or, when use_wsl is set, in WSL.
+func (p *Provisioner) command(dir, name string, args ...string) *exec.Cmd {
+	return p.commandEnv(dir, nil, name, args...)
+}
+
+// commandEnv is command with the environment overrides extra, which only this
+// command gets, added to those of env.
+func (p *Provisioner) commandEnv(dir string, extra []string, name string, args ...string) *exec.Cmd {
+	env := append(p.env(), extra...)
+	switch {
+	case p.config.ExecutionContainer != "":
+		args = p.executionContainerArgs(dir, name, args, env)
+		name = p.config.ExecutionContainerEngine
+	case p.config.UseWSL:
+		args = p.wslArgs(name, args)
+		name = "wsl.exe"
+		env = wslEnv(env)
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Dir = dir
+	return cmd
+}
+
+// executionContainerArgs returns the arguments of the engine to run the
+// command name with args in a container of execution_container. The files
+// that ansible uses are mounted at the same paths in the container, so that
+// the paths in the arguments and environment stay valid, and the environment
+// overrides are passed through by name, so that their values stay out of the
+// arguments.
+func (p *Provisioner) executionContainerArgs(dir, name string, args, env []string) []string {
+	wd := dir
+	if wd == "" {
+		wd, _ = os.Getwd()
+	}
+	wd, _ = filepath.Abs(wd)
+
+	runArgs := []string{"run", "--rm", "-i", "--network", p.config.ExecutionContainerNetwork, "-w", wd}
+	for _, mount := range p.executionContainerMounts(wd) {
+		runArgs = append(runArgs, "-v", mount+":"+mount)
+	}
+	for _, volume := range p.config.ExecutionContainerVolumes {
+		runArgs = append(runArgs, "-v", volume)
+	}
+	for _, name := range envNames(env) {
+		runArgs = append(runArgs, "-e", name)
+	}
+	runArgs = append(runArgs, p.config.ExecutionContainer, name)
+	return append(runArgs, args...)
+}
+
+// envNames returns the names of the variables of env, in order and without
+// duplicates.
+func envNames(env []string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, v := range env {
+		name := strings.SplitN(v, "=", 2)[0]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// executionContainerMounts returns the existing directories that ansible uses:
+// the working directory, the staging directory of the run, in which the
+// inventory and the other generated files are staged, the private data
+// directory of ansible-runner, and the directories of the playbooks, the
+// inventory, ansible.cfg, and the configured paths. A directory within another
+// is left out.
+func (p *Provisioner) executionContainerMounts(wd string) []string {
+	dirs := []string{wd}
+	if p.staging != "" {
+		dirs = append(dirs, p.staging)
+	} else if p.config.StagingDir != "" {
+		dirs = append(dirs, p.config.StagingDir)
+	}
+	if p.config.runnerDir != "" {
+		dirs = append(dirs, p.config.runnerDir)
+	}
+	if p.config.RunnerPrivateDataDir != "" {
+		dirs = append(dirs, p.config.RunnerPrivateDataDir)
+	}
+	for _, playbook := range p.playbooks() {
+		dirs = append(dirs, filepath.Dir(playbook))
+	}
+	for _, file := range []string{p.config.inventoryFile, p.config.InventoryFile, p.config.AnsibleCfgFile} {
+		if file != "" {
+			dirs = append(dirs, filepath.Dir(file))
+		}
+	}
+	dirs = append(dirs, p.rolesPaths()...)
+	dirs = append(dirs, p.collectionsPaths()...)
+	for _, paths := range p.pluginPaths() {
+		dirs = append(dirs, paths.dirs...)
+	}
+
+	var abs []string
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(wd, dir)
+		}
+		dir = filepath.Clean(dir)
+		if dir, err := filepath.EvalSymlinks(dir); err == nil {
+			abs = append(abs, dir)
+		}
+	}
+	sort.Strings(abs)
+
+	var mounts []string
+	for _, dir := range abs {
+		if n := len(mounts); n > 0 && within(dir, mounts[n-1]) {
+			continue
+		}
+		mounts = append(mounts, dir)
+	}
+	return mounts
+}
+
+// within reports whether path is dir or is in dir.
+func within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
@@ -0,0 +1,86 @@
+package ansible
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProvisioner_ExecutionContainer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+
+	staging, err := ioutil.TempDir("", "packer-staging")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(staging)
+	staging, _ = filepath.EvalSymlinks(staging)
+
+	var p Provisioner
+	p.staging = staging
+	p.config.Command = "ansible-playbook"
+	p.config.PlaybookFile = filepath.Join(dir, "playbook.yml")
+	p.config.WorkingDirectory = dir
+	p.config.ExecutionContainer = "quay.io/ansible/ansible-runner"
+	p.config.ExecutionContainerVolumes = []string{"/srv/keys:/keys:ro"}
+	p.config.AnsibleEnvVars = []string{"ANSIBLE_FORKS=1"}
+	if errs := p.prepareExecutionContainer(); len(errs) > 0 {
+		t.Fatalf("err: %v", errs)
+	}
+
+	cmd := p.ansibleCommand(p.config.PlaybookFile)
+	mounts := []string{dir, staging}
+	if staging < dir {
+		mounts = []string{staging, dir}
+	}
+	expected := []string{"docker", "run", "--rm", "-i", "--network", "host", "-w", dir}
+	for _, mount := range mounts {
+		expected = append(expected, "-v", mount+":"+mount)
+	}
+	expected = append(expected,
+		"-v", "/srv/keys:/keys:ro",
+		"-e", "ANSIBLE_RETRY_FILES_ENABLED",
+		"-e", "ANSIBLE_FORKS",
+		"quay.io/ansible/ansible-runner",
+		"ansible-playbook", p.config.PlaybookFile, "-i", "")
+	if !reflect.DeepEqual(cmd.Args, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmd.Args)
+	}
+	if cmd.Dir != dir {
+		t.Fatalf("expected the command to run in %s, got %s", dir, cmd.Dir)
+	}
+}
+
+func TestProvisioner_PrepareExecutionContainer(t *testing.T) {
+	var p Provisioner
+	p.config.ExecutionContainer = "ansible"
+	p.config.ExecutionContainerEngine = "rkt"
+	p.config.ExecutionContainerVolumes = []string{"/srv/keys"}
+	if errs := p.prepareExecutionContainer(); len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+}
+
+func TestWithin(t *testing.T) {
+	cases := []struct {
+		path, dir string
+		expected  bool
+	}{
+		{"/tmp", "/tmp", true},
+		{"/tmp/packer", "/tmp", true},
+		{"/tmpfoo", "/tmp", false},
+		{"/tmp", "/", true},
+	}
+	for _, c := range cases {
+		if within(c.path, c.dir) != c.expected {
+			t.Errorf("within(%q, %q): expected %t", c.path, c.dir, c.expected)
+		}
+	}
+}
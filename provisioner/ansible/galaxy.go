@@ -0,0 +1,444 @@
+package ansible
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// galaxyCacheMarker is written into an entry of galaxy_cache_dir once the
+// requirements have been installed into it, before it is renamed into place.
+const galaxyCacheMarker = ".packer-galaxy-complete"
+
+// galaxyCommands returns the ansible-galaxy commands that install the
+// dependencies of the playbooks that are not already cached.
+func (p *Provisioner) galaxyCommands() []*exec.Cmd {
+	return append(p.galaxyRoleCommands(), p.galaxyCollectionCommands()...)
+}
+
+// galaxyRoleCommands returns the commands that install the roles in
+// galaxy_file. When galaxy_parallelism is greater than one and the roles are
+// installed into a roles path, the roles are divided among up to that many
+// commands, which can be run concurrently, since each installs from its own
+// requirements file into its own directory of galaxyRoleDirs.
+func (p *Provisioner) galaxyRoleCommands() []*exec.Cmd {
+	p.galaxyRoleDirs = nil
+	if p.config.GalaxyFile == "" || p.galaxyCached(p.config.GalaxyRolesPath) {
+		return nil
+	}
+	single := []*exec.Cmd{p.galaxyCommand(p.galaxyRoleArgs())}
+	if p.config.GalaxyParallelism < 2 || p.galaxyRolesPath() == "" {
+		return single
+	}
+
+	f, err := os.Open(p.config.GalaxyFile)
+	if err != nil {
+		log.Printf("Error reading galaxy_file, installing its roles with a single command: %s", err)
+		return single
+	}
+	reqs, err := parseRoleRequirements(f)
+	f.Close()
+	if err != nil {
+		log.Printf("Error reading galaxy_file, installing its roles with a single command: %s", err)
+		return single
+	}
+	if len(reqs) < 2 {
+		return single
+	}
+
+	cmds, dirs, err := p.galaxyParallelCommands(reqs)
+	if err != nil {
+		log.Printf("Error preparing the concurrent installs, installing the roles of galaxy_file with a single command: %s", err)
+		return single
+	}
+	p.galaxyRoleDirs = dirs
+	return cmds
+}
+
+// galaxyParallelCommands divides reqs among up to galaxy_parallelism
+// commands, and returns them along with the directories into which they
+// install. The directories are next to the roles path, so that the roles can
+// be renamed into it.
+func (p *Provisioner) galaxyParallelCommands(reqs []roleRequirement) ([]*exec.Cmd, []string, error) {
+	n := p.config.GalaxyParallelism
+	if n > len(reqs) {
+		n = len(reqs)
+	}
+	chunks := make([][]map[string]string, n)
+	for i, req := range reqs {
+		chunks[i%n] = append(chunks[i%n], req.Keys)
+	}
+
+	staging, err := p.tempDir("galaxy")
+	if err != nil {
+		return nil, nil, err
+	}
+	p.track(staging)
+	path, _ := filepath.Abs(p.galaxyRolesPath())
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, nil, err
+	}
+
+	var cmds []*exec.Cmd
+	var dirs []string
+	for i, chunk := range chunks {
+		// JSON is also YAML, and ansible-galaxy requires the extension.
+		file := filepath.Join(staging, fmt.Sprintf("requirements-%d.yml", i))
+		b, _ := json.Marshal(chunk)
+		if err := ioutil.WriteFile(file, b, 0644); err != nil {
+			return nil, nil, err
+		}
+		dir, err := ioutil.TempDir(filepath.Dir(path), ".packer-galaxy-")
+		if err != nil {
+			return nil, nil, err
+		}
+		p.track(dir)
+		dirs = append(dirs, dir)
+
+		args := []string{"install", "-r", file, "-p", dir}
+		if p.config.GalaxyForceInstall {
+			args = append(args, "--force")
+		}
+		cmds = append(cmds, p.galaxyCommand(args))
+	}
+	return cmds, dirs, nil
+}
+
+// mergeGalaxyRoles moves the roles from galaxyRoleDirs into the roles path. A
+// role that is already there, e.g. a dependency that another command also
+// installed, is kept, as ansible-galaxy would, unless galaxy_force_install is
+// set.
+func (p *Provisioner) mergeGalaxyRoles() error {
+	path, _ := filepath.Abs(p.galaxyRolesPath())
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	for _, dir := range p.galaxyRoleDirs {
+		roles, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, role := range roles {
+			dst := filepath.Join(path, role.Name())
+			if _, err := os.Stat(dst); err == nil {
+				if !p.config.GalaxyForceInstall {
+					continue
+				}
+				if err := os.RemoveAll(dst); err != nil {
+					return err
+				}
+			}
+			if err := os.Rename(filepath.Join(dir, role.Name()), dst); err != nil {
+				return err
+			}
+		}
+		os.RemoveAll(dir)
+	}
+	return nil
+}
+
+// galaxyCollectionCommands returns the commands that install the collections
+// in galaxy_collections_file.
+func (p *Provisioner) galaxyCollectionCommands() []*exec.Cmd {
+	if p.config.GalaxyCollectionsFile == "" || p.galaxyCached(p.config.GalaxyCollectionsPath) {
+		return nil
+	}
+	return []*exec.Cmd{p.galaxyCommand(p.galaxyCollectionArgs())}
+}
+
+// prepareGalaxyCache sets galaxy_roles_path and galaxy_collections_path to
+// entries of galaxy_cache_dir that are keyed by the content of the
+// requirements files, and thus by the names and versions of the requirements,
+// and by the Galaxy server.
+func (p *Provisioner) prepareGalaxyCache() error {
+	if p.config.GalaxyRolesPath != "" || p.config.GalaxyCollectionsPath != "" {
+		return errors.New("galaxy_cache_dir cannot be used with galaxy_roles_path or galaxy_collections_path")
+	}
+
+	var err error
+	if p.config.GalaxyFile != "" {
+		p.config.GalaxyRolesPath, err = p.galaxyCacheEntry("roles", p.config.GalaxyFile)
+		if err != nil {
+			return err
+		}
+	}
+	if p.config.GalaxyCollectionsFile != "" {
+		p.config.GalaxyCollectionsPath, err = p.galaxyCacheEntry("collections", p.config.GalaxyCollectionsFile)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Provisioner) galaxyCacheEntry(kind, file string) (string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("galaxy_cache_dir: %s", err)
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", kind, p.config.GalaxyServer)
+	h.Write(b)
+	return filepath.Join(p.config.GalaxyCacheDir, kind+"-"+hex.EncodeToString(h.Sum(nil))[:16]), nil
+}
+
+// galaxyCached reports whether dir is an entry of galaxy_cache_dir into which
+// the requirements have already been installed.
+func (p *Provisioner) galaxyCached(dir string) bool {
+	if p.config.GalaxyCacheDir == "" || p.config.GalaxyForceInstall {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(dir, galaxyCacheMarker))
+	return err == nil
+}
+
+// galaxyCacheStage is an entry of galaxy_cache_dir that is being installed
+// into a temporary directory, which path points to until it is committed.
+type galaxyCacheStage struct {
+	path  *string
+	entry string
+	dir   string
+}
+
+// stageGalaxyCache points the paths of the entries of galaxy_cache_dir that
+// are not cached yet at new temporary directories of galaxy_cache_dir, so
+// that concurrent builds never install into the same directory, nor use an
+// entry before it is complete.
+func (p *Provisioner) stageGalaxyCache(ui packer.Ui) ([]galaxyCacheStage, error) {
+	var stages []galaxyCacheStage
+	for _, path := range []*string{&p.config.GalaxyRolesPath, &p.config.GalaxyCollectionsPath} {
+		if *path == "" {
+			continue
+		}
+		if p.galaxyCached(*path) {
+			ui.Say(fmt.Sprintf("Using cached Ansible Galaxy requirements in %s", *path))
+			continue
+		}
+		if err := os.MkdirAll(p.config.GalaxyCacheDir, 0755); err != nil {
+			return stages, err
+		}
+		dir, err := ioutil.TempDir(p.config.GalaxyCacheDir, ".install-")
+		if err != nil {
+			return stages, err
+		}
+		stages = append(stages, galaxyCacheStage{path, *path, dir})
+		*path = dir
+	}
+	return stages, nil
+}
+
+// unstageGalaxyCache points the paths back at the entries of galaxy_cache_dir
+// and removes what is left of the temporary directories.
+func unstageGalaxyCache(stages []galaxyCacheStage) {
+	for _, s := range stages {
+		*s.path = s.entry
+		os.RemoveAll(s.dir)
+	}
+}
+
+// commitGalaxyCache marks the temporary directory of s as complete and renames
+// it into place as the entry. An entry that another build completed first is
+// kept, unless galaxy_force_install is set, in which case it is replaced.
+func (p *Provisioner) commitGalaxyCache(s galaxyCacheStage) error {
+	if err := ioutil.WriteFile(filepath.Join(s.dir, galaxyCacheMarker), nil, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(s.dir, s.entry); err == nil {
+		return nil
+	}
+	if !p.config.GalaxyForceInstall && p.galaxyCached(s.entry) {
+		return nil
+	}
+
+	// The entry is moved aside first, since a directory cannot be renamed
+	// over one that is not empty.
+	old, err := ioutil.TempDir(p.config.GalaxyCacheDir, ".old-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(old)
+	if err := os.Rename(s.entry, filepath.Join(old, "entry")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(s.dir, s.entry); err != nil && !p.galaxyCached(s.entry) {
+		return err
+	}
+	return nil
+}
+
+// galaxyRoleArgs returns the arguments to install the roles in galaxy_file.
+func (p *Provisioner) galaxyRoleArgs() []string {
+	file, _ := filepath.Abs(p.config.GalaxyFile)
+	return append([]string{"install", "-r", file}, p.galaxyRoleOptions()...)
+}
+
+// galaxyRoleOptions returns the options with which roles are installed.
+func (p *Provisioner) galaxyRoleOptions() []string {
+	var args []string
+	if path := p.galaxyRolesPath(); path != "" {
+		path, _ = filepath.Abs(path)
+		args = append(args, "-p", path)
+	}
+	if p.config.GalaxyForceInstall {
+		args = append(args, "--force")
+	}
+	return args
+}
+
+// galaxyRolesPath returns the directory into which roles are installed:
+// galaxy_roles_path or else the first of roles_path.
+func (p *Provisioner) galaxyRolesPath() string {
+	if p.config.GalaxyRolesPath != "" {
+		return p.config.GalaxyRolesPath
+	}
+	if len(p.config.RolesPath) > 0 {
+		return p.config.RolesPath[0]
+	}
+	return ""
+}
+
+// galaxyCollectionArgs returns the arguments to install the collections in
+// galaxy_collections_file.
+func (p *Provisioner) galaxyCollectionArgs() []string {
+	file, _ := filepath.Abs(p.config.GalaxyCollectionsFile)
+	args := []string{"collection", "install", "-r", file}
+	if path := p.galaxyCollectionsPath(); path != "" {
+		path, _ = filepath.Abs(path)
+		args = append(args, "-p", path)
+	}
+	if p.config.GalaxyForceInstall {
+		args = append(args, "--force")
+	}
+	return args
+}
+
+// galaxyCollectionsPath returns the directory into which collections are
+// installed: galaxy_collections_path or else the first of collections_path.
+func (p *Provisioner) galaxyCollectionsPath() string {
+	if p.config.GalaxyCollectionsPath != "" {
+		return p.config.GalaxyCollectionsPath
+	}
+	if len(p.config.CollectionsPath) > 0 {
+		return p.config.CollectionsPath[0]
+	}
+	return ""
+}
+
+// galaxyServerID is the name of the Galaxy server that galaxyServerEnv
+// configures.
+const galaxyServerID = "packer"
+
+// galaxyServerArgs returns the arguments that select the Galaxy server, when
+// there is no API token. With a token, the server is configured by
+// galaxyServerEnv instead.
+func (p *Provisioner) galaxyServerArgs() []string {
+	if p.config.GalaxyServer == "" || p.config.GalaxyAPIToken != "" {
+		return nil
+	}
+	return []string{"--server", p.config.GalaxyServer}
+}
+
+// galaxyServerEnv returns the environment overrides that configure the Galaxy
+// server, the default one unless galaxy_server is set, along with the API
+// token, so that the token is not on the command line of ansible-galaxy.
+func (p *Provisioner) galaxyServerEnv() []string {
+	if p.config.GalaxyAPIToken == "" {
+		return nil
+	}
+	server := p.config.GalaxyServer
+	if server == "" {
+		server = "https://galaxy.ansible.com"
+	}
+	prefix := "ANSIBLE_GALAXY_SERVER_" + strings.ToUpper(galaxyServerID) + "_"
+	return []string{
+		"ANSIBLE_GALAXY_SERVER_LIST=" + galaxyServerID,
+		prefix + "URL=" + server,
+		prefix + "TOKEN=" + p.config.GalaxyAPIToken,
+	}
+}
+
+func (p *Provisioner) galaxyCommand(args []string) *exec.Cmd {
+	args = append(args, p.galaxyServerArgs()...)
+	return p.commandEnv(p.config.WorkingDirectory, p.galaxyServerEnv(), p.config.GalaxyCommand, args...)
+}
+
+// executeGalaxy installs the dependencies of the playbooks. With
+// galaxy_cache_dir, they are installed into temporary directories that only
+// become entries of the cache once installed and, with galaxy_verify,
+// verified.
+func (p *Provisioner) executeGalaxy(ui packer.Ui) error {
+	if p.config.GalaxyCacheDir != "" {
+		stages, err := p.stageGalaxyCache(ui)
+		defer unstageGalaxyCache(stages)
+		if err != nil {
+			return fmt.Errorf("Error updating galaxy_cache_dir: %s", err)
+		}
+		if err := p.installGalaxy(ui); err != nil {
+			return err
+		}
+		for _, s := range stages {
+			if err := p.commitGalaxyCache(s); err != nil {
+				return fmt.Errorf("Error updating galaxy_cache_dir: %s", err)
+			}
+		}
+		return nil
+	}
+	return p.installGalaxy(ui)
+}
+
+// installGalaxy installs the dependencies of the playbooks that are not
+// cached, and verifies them if galaxy_verify is set.
+func (p *Provisioner) installGalaxy(ui packer.Ui) error {
+	if err := p.runGalaxy(ui, p.galaxyRoleCommands()); err != nil {
+		return err
+	}
+	if len(p.galaxyRoleDirs) > 0 {
+		if err := p.mergeGalaxyRoles(); err != nil {
+			return fmt.Errorf("Error installing the roles of galaxy_file: %s", err)
+		}
+	}
+	if err := p.runGalaxy(ui, p.galaxyCollectionCommands()); err != nil {
+		return err
+	}
+	if p.config.GalaxyVerify {
+		return p.verifyGalaxy(ui)
+	}
+	return nil
+}
+
+// runGalaxy runs cmds concurrently and returns the first error.
+func (p *Provisioner) runGalaxy(ui packer.Ui, cmds []*exec.Cmd) error {
+	if len(cmds) > 1 {
+		ui = newUi(ui)
+	}
+
+	errs := make([]error, len(cmds))
+	var wg sync.WaitGroup
+	for i, cmd := range cmds {
+		ui.Say(fmt.Sprintf("Executing Ansible Galaxy: %s", p.redactSecrets(strings.Join(cmd.Args, " "))))
+		wg.Add(1)
+		go func(i int, cmd *exec.Cmd) {
+			defer wg.Done()
+			errs[i] = runCommand(ui, cmd, ui.Message, ui.Message)
+		}(i, cmd)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return fmt.Errorf("Error executing Ansible Galaxy: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package ansible
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProvisioner_GalaxyCommands(t *testing.T) {
+	var p Provisioner
+	p.config.GalaxyCommand = "ansible-galaxy"
+
+	if cmds := p.galaxyCommands(); len(cmds) != 0 {
+		t.Fatalf("expected no commands without galaxy_file, got %d", len(cmds))
+	}
+
+	p.config.GalaxyFile = "requirements.yml"
+	p.config.RolesPath = []string{"roles", "vendor/roles"}
+	file, _ := filepath.Abs("requirements.yml")
+	roles, _ := filepath.Abs("roles")
+
+	cmds := p.galaxyCommands()
+	if len(cmds) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(cmds))
+	}
+	expected := []string{"ansible-galaxy", "install", "-r", file, "-p", roles}
+	if !reflect.DeepEqual(cmds[0].Args, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmds[0].Args)
+	}
+}
+
+func TestProvisioner_GalaxyCollections(t *testing.T) {
+	var p Provisioner
+	p.config.GalaxyCommand = "ansible-galaxy"
+	p.config.GalaxyCollectionsFile = "requirements.yml"
+	file, _ := filepath.Abs("requirements.yml")
+
+	cmds := p.galaxyCommands()
+	if len(cmds) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(cmds))
+	}
+	expected := []string{"ansible-galaxy", "collection", "install", "-r", file}
+	if !reflect.DeepEqual(cmds[0].Args, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmds[0].Args)
+	}
+
+	p.config.CollectionsPath = []string{"collections"}
+	p.config.GalaxyCollectionsPath = "vendor"
+	vendor, _ := filepath.Abs("vendor")
+	expected = append(expected, "-p", vendor)
+	if args := p.galaxyCommands()[0].Args; !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected %v, got %v", expected, args)
+	}
+	if paths := p.collectionsPaths(); !reflect.DeepEqual(paths, []string{"vendor", "collections"}) {
+		t.Fatalf("unexpected collections paths: %v", paths)
+	}
+}
+
+func TestProvisioner_GalaxyForceInstall(t *testing.T) {
+	var p Provisioner
+	p.config.GalaxyCommand = "ansible-galaxy"
+	p.config.GalaxyFile = "requirements.yml"
+	p.config.GalaxyCollectionsFile = "requirements.yml"
+	p.config.GalaxyRolesPath = "vendor/roles"
+	p.config.RolesPath = []string{"roles"}
+	p.config.GalaxyForceInstall = true
+	file, _ := filepath.Abs("requirements.yml")
+	vendor, _ := filepath.Abs("vendor/roles")
+
+	cmds := p.galaxyCommands()
+	if len(cmds) != 2 {
+		t.Fatalf("expected 2 commands, got %d", len(cmds))
+	}
+	expected := []string{"ansible-galaxy", "install", "-r", file, "-p", vendor, "--force"}
+	if !reflect.DeepEqual(cmds[0].Args, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmds[0].Args)
+	}
+	expected = []string{"ansible-galaxy", "collection", "install", "-r", file, "--force"}
+	if !reflect.DeepEqual(cmds[1].Args, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmds[1].Args)
+	}
+	if paths := p.rolesPaths(); !reflect.DeepEqual(paths, []string{"vendor/roles", "roles"}) {
+		t.Fatalf("unexpected roles paths: %v", paths)
+	}
+}
+
+func TestProvisioner_GalaxyServer(t *testing.T) {
+	var p Provisioner
+	p.config.GalaxyCommand = "ansible-galaxy"
+	p.config.GalaxyCollectionsFile = "requirements.yml"
+	p.config.GalaxyServer = "https://galaxy.example.com"
+	file, _ := filepath.Abs("requirements.yml")
+
+	cmd := p.galaxyCommands()[0]
+	expected := []string{"ansible-galaxy", "collection", "install", "-r", file,
+		"--server", "https://galaxy.example.com"}
+	if !reflect.DeepEqual(cmd.Args, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmd.Args)
+	}
+
+	p.config.GalaxyAPIToken = "secret"
+	cmd = p.galaxyCommands()[0]
+	expected = expected[:len(expected)-2]
+	if !reflect.DeepEqual(cmd.Args, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmd.Args)
+	}
+	env := strings.Join(cmd.Env, "\n")
+	for _, v := range []string{
+		"ANSIBLE_GALAXY_SERVER_LIST=packer",
+		"ANSIBLE_GALAXY_SERVER_PACKER_URL=https://galaxy.example.com",
+		"ANSIBLE_GALAXY_SERVER_PACKER_TOKEN=secret",
+	} {
+		if !strings.Contains(env, v) {
+			t.Fatalf("expected %s in the environment of %v", v, cmd.Args)
+		}
+	}
+	if strings.Contains(strings.Join(p.env(), "\n"), "secret") {
+		t.Fatal("expected the token only in the environment of ansible-galaxy")
+	}
+
+	p.config.GalaxyServer = ""
+	if env := p.galaxyServerEnv(); env[1] != "ANSIBLE_GALAXY_SERVER_PACKER_URL=https://galaxy.ansible.com" {
+		t.Fatalf("expected the default server, got %v", env)
+	}
+}
+
+func TestProvisioner_GalaxyCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "galaxy")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	requirements := filepath.Join(dir, "requirements.yml")
+	if err := ioutil.WriteFile(requirements, []byte("- src: geerlingguy.java\n"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var p Provisioner
+	p.config.GalaxyCommand = "ansible-galaxy"
+	p.config.GalaxyFile = requirements
+	p.config.GalaxyCacheDir = filepath.Join(dir, "cache")
+	if err := p.prepareGalaxyCache(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	entry := p.config.GalaxyRolesPath
+	if filepath.Dir(entry) != p.config.GalaxyCacheDir {
+		t.Fatalf("expected an entry of %s, got %s", p.config.GalaxyCacheDir, entry)
+	}
+
+	if cmds := p.galaxyCommands(); len(cmds) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(cmds))
+	}
+
+	stages, err := p.stageGalaxyCache(new(ui))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(stages) != 1 || filepath.Dir(p.config.GalaxyRolesPath) != p.config.GalaxyCacheDir || p.config.GalaxyRolesPath == entry {
+		t.Fatalf("expected to install into a temporary directory of the cache, got %s", p.config.GalaxyRolesPath)
+	}
+	if _, err := os.Stat(entry); !os.IsNotExist(err) {
+		t.Fatalf("expected no entry before the install is committed: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(p.config.GalaxyRolesPath, "role"), nil, 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := p.commitGalaxyCache(stages[0]); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	unstageGalaxyCache(stages)
+	if p.config.GalaxyRolesPath != entry {
+		t.Fatalf("expected the path of the entry, got %s", p.config.GalaxyRolesPath)
+	}
+	if _, err := os.Stat(filepath.Join(entry, "role")); err != nil {
+		t.Fatalf("expected the install in the entry: %s", err)
+	}
+	if cmds := p.galaxyCommands(); len(cmds) != 0 {
+		t.Fatalf("expected no commands once cached, got %d", len(cmds))
+	}
+
+	p.config.GalaxyForceInstall = true
+	if cmds := p.galaxyCommands(); len(cmds) != 1 {
+		t.Fatalf("expected 1 command with galaxy_force_install, got %d", len(cmds))
+	}
+	if stages, err = p.stageGalaxyCache(new(ui)); err != nil || len(stages) != 1 {
+		t.Fatalf("expected to reinstall, got %v (%v)", stages, err)
+	}
+	if err := p.commitGalaxyCache(stages[0]); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	unstageGalaxyCache(stages)
+	if _, err := os.Stat(filepath.Join(entry, "role")); !os.IsNotExist(err) {
+		t.Fatalf("expected the entry to be replaced: %v", err)
+	}
+	if entries, _ := ioutil.ReadDir(p.config.GalaxyCacheDir); len(entries) != 1 {
+		t.Fatalf("expected only the entry in the cache, got %v", entries)
+	}
+	p.config.GalaxyForceInstall = false
+
+	if err := ioutil.WriteFile(requirements, []byte("- src: geerlingguy.java\n  version: 1.9.0\n"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	p.config.GalaxyRolesPath = ""
+	if err := p.prepareGalaxyCache(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if p.config.GalaxyRolesPath == entry {
+		t.Fatal("expected a different entry for different requirements")
+	}
+}
+
+func TestProvisioner_GalaxyParallelism(t *testing.T) {
+	dir, err := ioutil.TempDir("", "galaxy")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	requirements := filepath.Join(dir, "requirements.yml")
+	if err := ioutil.WriteFile(requirements, []byte("- src: a.one\n- src: https://example.com/b.git\n  scm: git\n  version: 1.0\n- src: c.three\n"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var p Provisioner
+	p.config.StagingDir = dir
+	p.config.GalaxyCommand = "ansible-galaxy"
+	p.config.GalaxyFile = requirements
+	p.config.GalaxyRolesPath = filepath.Join(dir, "roles")
+	p.config.GalaxyParallelism = 2
+	defer p.cleanup()
+
+	cmds := p.galaxyRoleCommands()
+	if len(cmds) != 2 || len(p.galaxyRoleDirs) != 2 {
+		t.Fatalf("expected 2 commands, got %d", len(cmds))
+	}
+	for i, expected := range []string{
+		`[{"src":"a.one"},{"src":"c.three"}]`,
+		`[{"scm":"git","src":"https://example.com/b.git","version":"1.0"}]`,
+	} {
+		args := cmds[i].Args
+		if len(args) != 6 || args[1] != "install" || args[2] != "-r" || args[4] != "-p" || args[5] != p.galaxyRoleDirs[i] {
+			t.Fatalf("unexpected command %v", args)
+		}
+		if filepath.Dir(args[5]) != dir {
+			t.Fatalf("expected %s next to the roles path", args[5])
+		}
+		if b, err := ioutil.ReadFile(args[3]); err != nil || string(b) != expected {
+			t.Fatalf("expected the requirements %s, got %s (%v)", expected, b, err)
+		}
+	}
+
+	// Both commands installed the same dependency, which is kept once.
+	for i, dir := range p.galaxyRoleDirs {
+		for _, role := range []string{[]string{"a.one", "b"}[i], "common"} {
+			if err := os.Mkdir(filepath.Join(dir, role), 0755); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+		}
+	}
+	if err := p.mergeGalaxyRoles(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	roles, _ := ioutil.ReadDir(p.config.GalaxyRolesPath)
+	if len(roles) != 3 {
+		t.Fatalf("expected 3 roles, got %v", roles)
+	}
+	for _, dir := range p.galaxyRoleDirs {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed", dir)
+		}
+	}
+
+	p.config.GalaxyParallelism = 1
+	if cmds := p.galaxyRoleCommands(); len(cmds) != 1 || len(p.galaxyRoleDirs) != 0 {
+		t.Fatalf("expected 1 command, got %d", len(cmds))
+	}
+}
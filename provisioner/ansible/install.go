@@ -0,0 +1,168 @@
+package ansible
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// prepareInstall checks the install_ansible options.
+func (p *Provisioner) prepareInstall() []error {
+	if p.config.InstallAnsibleVersion == "" {
+		return nil
+	}
+	var errs []error
+	if _, err := parseVersionNumber(p.config.InstallAnsibleVersion); err != nil {
+		errs = append(errs, fmt.Errorf("install_ansible_version: %s", err))
+	}
+	switch p.config.InstallAnsibleMethod {
+	case "":
+		p.config.InstallAnsibleMethod = "virtualenv"
+	case "virtualenv", "user":
+	default:
+		errs = append(errs, fmt.Errorf("install_ansible_method: %s must be one of virtualenv or user", p.config.InstallAnsibleMethod))
+	}
+	if p.config.InstallAnsibleDir == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			home = os.TempDir()
+		}
+		p.config.InstallAnsibleDir = filepath.Join(home, ".packer.d", "ansible", p.config.InstallAnsibleVersion)
+	}
+	if dir, err := filepath.Abs(p.config.InstallAnsibleDir); err == nil {
+		p.config.InstallAnsibleDir = dir
+	}
+	if p.config.InstallAnsiblePython == "" {
+		p.config.InstallAnsiblePython = "python3"
+	}
+	if p.config.AnsibleVirtualenv != "" {
+		errs = append(errs, errors.New("install_ansible_version cannot be used with ansible_virtualenv; set ansible_virtualenv_version"))
+	}
+	if p.config.ExecutionContainer != "" {
+		errs = append(errs, errors.New("install_ansible_version cannot be used with execution_container"))
+	}
+	if p.config.UseWSL {
+		errs = append(errs, errors.New("install_ansible_version cannot be used with use_wsl"))
+	}
+	return errs
+}
+
+// ansiblePackage returns the pip requirement of the package that provides
+// ansible-playbook at version: ansible up to 2.9, ansible-base for 2.10, and
+// ansible-core from 2.11 on.
+func ansiblePackage(version string) string {
+	pkg := "ansible-core"
+	if v, err := parseVersionNumber(version); err == nil {
+		switch {
+		case compareVersions(v, []int{2, 10}) < 0:
+			pkg = "ansible"
+		case compareVersions(v, []int{2, 11}) < 0:
+			pkg = "ansible-base"
+		}
+	}
+	return pkg + "==" + version
+}
+
+// sameVersion reports whether the versions a and b are the same.
+func sameVersion(a, b string) bool {
+	va, err := parseVersionNumber(a)
+	if err != nil {
+		return false
+	}
+	vb, err := parseVersionNumber(b)
+	return err == nil && compareVersions(va, vb) == 0
+}
+
+// installAnsible installs install_ansible_version and points the ansible
+// commands at it, unless command is already that version.
+func (p *Provisioner) installAnsible(ui packer.Ui) error {
+	version := p.config.InstallAnsibleVersion
+	if installed := p.ansibleVersion(); sameVersion(installed, version) {
+		ui.Say(fmt.Sprintf("Ansible %s is installed", installed))
+		return nil
+	}
+
+	// The builds that run at the same time share install_ansible_dir, and
+	// would install into it over each other.
+	unlock, err := lockInstall(ui, p.config.InstallAnsibleDir)
+	if err != nil {
+		return fmt.Errorf("Error installing Ansible %s: %s", version, err)
+	}
+	defer unlock()
+
+	pkg := ansiblePackage(version)
+	switch p.config.InstallAnsibleMethod {
+	case "user":
+		ui.Say(fmt.Sprintf("Installing %s into the user site of %s", pkg, p.config.InstallAnsiblePython))
+		cmd := exec.Command(p.config.InstallAnsiblePython, "-m", "pip", "install", "--user", pkg)
+		cmd.Env = append(os.Environ(), p.env()...)
+		if err := runCommand(ui, cmd, ui.Message, ui.Message); err != nil {
+			return fmt.Errorf("Error installing Ansible %s: %s", version, err)
+		}
+		var stdout bytes.Buffer
+		cmd = exec.Command(p.config.InstallAnsiblePython, "-m", "site", "--user-base")
+		cmd.Stdout = &stdout
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("Error determining the user site of %s: %s", p.config.InstallAnsiblePython, err)
+		}
+		p.useAnsibleBin(filepath.Join(strings.TrimSpace(stdout.String()), "bin"))
+	default:
+		p.config.AnsibleVirtualenv = p.config.InstallAnsibleDir
+		p.config.AnsibleVirtualenvPython = p.config.InstallAnsiblePython
+		p.useAnsibleBin(filepath.Join(p.config.AnsibleVirtualenv, "bin"))
+		if err := p.setupVirtualenv(ui, pkg); err != nil {
+			return err
+		}
+	}
+
+	if installed := p.ansibleVersion(); !sameVersion(installed, version) {
+		return fmt.Errorf("Error installing Ansible %s: %s is version %q", version, p.config.Command, installed)
+	}
+	return nil
+}
+
+var (
+	// installLockWait is how often a build polls the lock of another build.
+	installLockWait = time.Second
+	// installLockStale is the age after which a lock is left over by a
+	// build that was killed, and is removed.
+	installLockStale = 30 * time.Minute
+)
+
+// lockInstall waits for the builds that install into dir to finish and locks
+// it, with a lock file next to it. It returns the function that removes the
+// lock.
+func lockInstall(ui packer.Ui, dir string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return nil, err
+	}
+	lock := dir + ".lock"
+	waiting := false
+	for {
+		f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			fmt.Fprintln(f, os.Getpid())
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > installLockStale {
+			os.Remove(lock)
+			continue
+		}
+		if !waiting {
+			ui.Say(fmt.Sprintf("Waiting for another build to finish installing into %s", dir))
+			waiting = true
+		}
+		time.Sleep(installLockWait)
+	}
+}
@@ -0,0 +1,131 @@
+package ansible
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAnsiblePackage(t *testing.T) {
+	cases := map[string]string{
+		"2.9.27": "ansible==2.9.27",
+		"2.10.7": "ansible-base==2.10.7",
+		"2.15.1": "ansible-core==2.15.1",
+	}
+	for version, expected := range cases {
+		if pkg := ansiblePackage(version); pkg != expected {
+			t.Errorf("ansiblePackage(%q): expected %q, got %q", version, expected, pkg)
+		}
+	}
+}
+
+func TestProvisioner_PrepareInstall(t *testing.T) {
+	var p Provisioner
+	p.config.InstallAnsibleVersion = "2.9.27"
+	if errs := p.prepareInstall(); len(errs) > 0 {
+		t.Fatalf("err: %v", errs)
+	}
+	if p.config.InstallAnsibleMethod != "virtualenv" || p.config.InstallAnsiblePython != "python3" {
+		t.Fatalf("unexpected defaults %s, %s", p.config.InstallAnsibleMethod, p.config.InstallAnsiblePython)
+	}
+	if filepath.Base(p.config.InstallAnsibleDir) != "2.9.27" {
+		t.Fatalf("expected install_ansible_dir to be per version, got %s", p.config.InstallAnsibleDir)
+	}
+
+	p = Provisioner{}
+	p.config.InstallAnsibleVersion = "latest"
+	p.config.InstallAnsibleMethod = "apt"
+	if errs := p.prepareInstall(); len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+}
+
+func TestProvisioner_InstallAnsibleUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The python installs ansible-playbook into the user base, dir.
+	python := filepath.Join(dir, "python")
+	script := `#!/bin/sh
+case "$*" in
+"-m pip install --user ansible-core==2.15.1")
+	mkdir -p "` + dir + `/bin"
+	printf '#!/bin/sh\necho "ansible-playbook [core 2.15.1]"\n' > "` + dir + `/bin/ansible-playbook"
+	chmod +x "` + dir + `/bin/ansible-playbook" ;;
+"-m site --user-base")
+	echo "` + dir + `" ;;
+*)
+	exit 1 ;;
+esac
+`
+	if err := ioutil.WriteFile(python, []byte(script), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var p Provisioner
+	p.config.Command = "ansible-playbook-missing"
+	p.config.InstallAnsibleVersion = "2.15.1"
+	p.config.InstallAnsibleMethod = "user"
+	p.config.InstallAnsibleDir = filepath.Join(dir, "venv")
+	p.config.InstallAnsiblePython = python
+	if err := p.installAnsible(new(ui)); err == nil {
+		t.Fatal("should error if the installation does not provide command")
+	}
+
+	p.config.Command = "ansible-playbook"
+	if err := p.installAnsible(new(ui)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := filepath.Join(dir, "bin", "ansible-playbook"); p.config.Command != expected {
+		t.Fatalf("expected command to be %s, got %s", expected, p.config.Command)
+	}
+
+	// The installed version is not installed again.
+	p.config.InstallAnsiblePython = filepath.Join(dir, "nonexistent")
+	if err := p.installAnsible(new(ui)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestLockInstall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	unlock, err := lockInstall(new(ui), filepath.Join(dir, "2.15.1"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	wait := installLockWait
+	installLockWait = 10 * time.Millisecond
+	defer func() { installLockWait = wait }()
+
+	locked := make(chan func())
+	go func() {
+		unlock, _ := lockInstall(new(ui), filepath.Join(dir, "2.15.1"))
+		locked <- unlock
+	}()
+	select {
+	case <-locked:
+		t.Fatal("should wait for the lock of another build")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case unlock := <-locked:
+		unlock()
+	case <-time.After(time.Second):
+		t.Fatal("should lock once the other build is done")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2.15.1.lock")); !os.IsNotExist(err) {
+		t.Fatalf("expected the lock to be removed: %v", err)
+	}
+}
@@ -0,0 +1,594 @@
+package ansible
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// generateInventory writes the inventory, in inventory_directory or else in a
+// new directory of the staging directory, or of the share directory when
+// share_proxy is set, along with the variables files, and sets inventoryFile.
+// When inventory_file is set, the directory also links to it, so that ansible
+// reads both, and the inventory_file is left untouched.
+func (p *Provisioner) generateInventory() error {
+	ext := ""
+	if p.config.InventoryFormat == "yaml" {
+		// The yaml inventory plugin only reads files with these extensions.
+		ext = ".yml"
+	}
+
+	var name string
+	if len(p.config.InventoryDirectory) > 0 {
+		tf, err := ioutil.TempFile(p.config.InventoryDirectory, "packer-provisioner-ansible")
+		if err != nil {
+			return err
+		}
+		tf.Close()
+		name = tf.Name() + ext
+		if err := os.Rename(tf.Name(), name); err != nil {
+			os.Remove(tf.Name())
+			return err
+		}
+		p.trackInventory(name)
+	} else {
+		var dir string
+		var err error
+		if p.sharesProxy() {
+			dir, err = p.sharedInventoryDir()
+		} else {
+			dir, err = p.tempDir("inventory")
+		}
+		if err != nil {
+			return err
+		}
+		p.trackInventory(dir)
+		if err := p.writeInventoryVars(dir); err != nil {
+			return err
+		}
+		name = filepath.Join(dir, "hosts"+ext)
+		if len(p.config.InventoryFile) > 0 {
+			// The entries of the inventory_file are linked by their own names.
+			name = filepath.Join(dir, "packer-provisioner-ansible"+ext)
+		}
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case p.config.inventoryTemplate != nil:
+		if p.config.InventoryFormat == "script" {
+			err = f.Chmod(0755)
+		}
+		if err == nil {
+			err = p.config.inventoryTemplate.Execute(f, p.inventoryTemplateData())
+		}
+	case p.config.InventoryFormat == "yaml":
+		err = p.writeYAMLInventory(f)
+	case p.config.InventoryFormat == "script":
+		if err = f.Chmod(0755); err == nil {
+			err = p.writeScriptInventory(f)
+		}
+	default:
+		err = p.writeInventory(f)
+	}
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	p.config.inventoryFile = name
+	if len(p.config.ConstructedInventory) > 0 {
+		// The constructed inventory is named so that ansible reads it after
+		// the hosts, and the whole directory is the inventory.
+		dir := filepath.Dir(name)
+		if err := p.writeConstructedInventory(filepath.Join(dir, "hosts_constructed.yml")); err != nil {
+			return err
+		}
+		p.config.inventoryFile = dir
+	}
+	if len(p.config.InventoryFile) > 0 {
+		// The whole directory is the inventory.
+		dir := filepath.Dir(name)
+		if err := linkInventory(dir, p.config.InventoryFile); err != nil {
+			return err
+		}
+		p.config.inventoryFile = dir
+	}
+	return nil
+}
+
+// linkInventory links the inventory in dir to inventory, which is a file or a
+// directory. A file is linked along with the group_vars and host_vars
+// directories next to it, and a directory entry by entry, so that ansible
+// reads the variables from dir as it would for inventory. It fails rather
+// than replace an existing entry of dir.
+func linkInventory(dir, inventory string) error {
+	inventory, err := filepath.Abs(inventory)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(inventory)
+	if err != nil {
+		return err
+	}
+
+	var targets []string
+	if fi.IsDir() {
+		entries, err := ioutil.ReadDir(inventory)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			targets = append(targets, filepath.Join(inventory, entry.Name()))
+		}
+	} else {
+		targets = append(targets, inventory)
+		for _, sub := range []string{"group_vars", "host_vars"} {
+			target := filepath.Join(filepath.Dir(inventory), sub)
+			if _, err := os.Stat(target); err == nil {
+				targets = append(targets, target)
+			}
+		}
+	}
+
+	for _, target := range targets {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.Base(target))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trackInventory tracks the generated inventory, unless keep_inventory_file is
+// set or it is shared with the later blocks, in which case it is recorded as
+// kept.
+func (p *Provisioner) trackInventory(name string) {
+	if p.config.KeepInventoryFile || p.sharesProxy() {
+		p.keptInventory = name
+		return
+	}
+	p.track(name)
+}
+
+// reportKeptInventory reports the kept inventory, unless it is gone or, with
+// keep_files, was reported along with the other generated files.
+func (p *Provisioner) reportKeptInventory(ui packer.Ui) {
+	if len(p.keptInventory) == 0 || p.keepFiles() {
+		return
+	}
+	if _, err := os.Stat(p.keptInventory); err != nil {
+		return
+	}
+	ui.Message(fmt.Sprintf("Keeping inventory %s", p.keptInventory))
+}
+
+// inventoryTemplateData is the data of inventory_file_template.
+type inventoryTemplateData struct {
+	HostAlias string
+	Hosts     []string
+	Address   string
+	Port      string
+	User      string
+	KeyFile   string
+}
+
+// parseInventoryTemplate parses text as an inventory_file_template, and
+// executes it once so that references to unknown fields are reported early.
+func parseInventoryTemplate(text string) (*template.Template, error) {
+	t, err := template.New("inventory_file_template").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(ioutil.Discard, inventoryTemplateData{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (p *Provisioner) inventoryTemplateData() inventoryTemplateData {
+	d := inventoryTemplateData{HostAlias: p.config.HostAlias, Hosts: p.hosts()}
+	for _, v := range p.hostVars() {
+		switch v.name {
+		case "ansible_ssh_host", "ansible_host":
+			d.Address = v.value
+		case "ansible_ssh_port", "ansible_port":
+			d.Port = v.value
+		case "ansible_ssh_user", "ansible_user":
+			d.User = v.value
+		case "ansible_ssh_private_key_file":
+			d.KeyFile = v.value
+		}
+	}
+	return d
+}
+
+// writeInventory writes an INI inventory of the machine, reached through the
+// SSH proxy, to w.
+func (p *Provisioner) writeInventory(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	hosts := p.hosts()
+	for _, host := range hosts {
+		fmt.Fprint(b, host)
+		for _, v := range p.hostVars() {
+			fmt.Fprintf(b, " %s=%s", v.name, shellQuote(v.value))
+		}
+		fmt.Fprintln(b)
+	}
+
+	for _, group := range p.config.Groups {
+		fmt.Fprintf(b, "\n[%s]\n%s\n", group, strings.Join(hosts, "\n"))
+	}
+	for _, group := range p.config.EmptyGroups {
+		fmt.Fprintf(b, "\n[%s]\n", group)
+	}
+
+	for _, parent := range sortedKeys(p.config.GroupChildren) {
+		fmt.Fprintf(b, "\n[%s:children]\n", parent)
+		for _, child := range p.config.GroupChildren[parent] {
+			fmt.Fprintln(b, child)
+		}
+	}
+
+	groups := make([]string, 0, len(p.config.InventoryGroupVars))
+	for group := range p.config.InventoryGroupVars {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		fmt.Fprintf(b, "\n[%s:vars]\n", group)
+		vars := p.config.InventoryGroupVars[group]
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			// Values in :vars sections are strings, and are not unquoted.
+			fmt.Fprintf(b, "%s=%s\n", name, vars[name])
+		}
+	}
+	return b.Flush()
+}
+
+// yamlGroup is a group of a YAML inventory.
+type yamlGroup struct {
+	Hosts    map[string]interface{} `json:"hosts,omitempty"`
+	Vars     map[string]string      `json:"vars,omitempty"`
+	Children map[string]*yamlGroup  `json:"children,omitempty"`
+}
+
+// writeYAMLInventory writes an inventory for the yaml inventory plugin to w.
+// It is written as JSON, which is also YAML, so that values need no quoting.
+func (p *Provisioner) writeYAMLInventory(w io.Writer) error {
+	all := &yamlGroup{
+		Hosts:    make(map[string]interface{}),
+		Children: make(map[string]*yamlGroup),
+	}
+	for _, host := range p.hosts() {
+		all.Hosts[host] = p.hostVarsMap()
+	}
+	group := func(name string) *yamlGroup {
+		if g, ok := all.Children[name]; ok {
+			return g
+		}
+		g := &yamlGroup{}
+		all.Children[name] = g
+		return g
+	}
+	for _, name := range p.config.Groups {
+		g := group(name)
+		g.Hosts = make(map[string]interface{})
+		for _, host := range p.hosts() {
+			g.Hosts[host] = nil
+		}
+	}
+	for _, name := range p.config.EmptyGroups {
+		group(name)
+	}
+	for parent, children := range p.config.GroupChildren {
+		g := group(parent)
+		if g.Children == nil {
+			g.Children = make(map[string]*yamlGroup)
+		}
+		for _, child := range children {
+			g.Children[child] = &yamlGroup{}
+		}
+	}
+
+	for name, vars := range p.config.InventoryGroupVars {
+		if name == "all" {
+			all.Vars = vars
+			continue
+		}
+		group(name).Vars = vars
+	}
+
+	b, err := json.MarshalIndent(map[string]*yamlGroup{"all": all}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// scriptGroup is a group of the output of a dynamic inventory script.
+type scriptGroup struct {
+	Hosts    []string          `json:"hosts"`
+	Vars     map[string]string `json:"vars,omitempty"`
+	Children []string          `json:"children,omitempty"`
+}
+
+// writeScriptInventory writes a dynamic inventory script to w. The script
+// prints the whole inventory, including the host variables in _meta, for
+// --list, and an empty object for --host.
+func (p *Provisioner) writeScriptInventory(w io.Writer) error {
+	inv := make(map[string]interface{})
+	group := func(name string) *scriptGroup {
+		if g, ok := inv[name].(*scriptGroup); ok {
+			return g
+		}
+		g := &scriptGroup{Hosts: []string{}}
+		inv[name] = g
+		return g
+	}
+	hosts := p.hosts()
+	group("all").Hosts = hosts
+	for _, name := range p.config.Groups {
+		group(name).Hosts = hosts
+	}
+	for _, name := range p.config.EmptyGroups {
+		group(name)
+	}
+	for _, parent := range sortedKeys(p.config.GroupChildren) {
+		g := group(parent)
+		g.Children = append(g.Children, p.config.GroupChildren[parent]...)
+	}
+	for name, vars := range p.config.InventoryGroupVars {
+		group(name).Vars = vars
+	}
+	hostvars := make(map[string]interface{})
+	for _, host := range hosts {
+		hostvars[host] = p.hostVarsMap()
+	}
+	inv["_meta"] = map[string]interface{}{"hostvars": hostvars}
+
+	b, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, `#!/bin/sh
+# Generated by packer-provisioner-ansible.
+if [ "$1" = "--host" ]; then
+  echo '{}'
+  exit 0
+fi
+cat <<'PACKER_INVENTORY'
+%s
+PACKER_INVENTORY
+`, b)
+	return err
+}
+
+// writeConstructedInventory writes the configuration of the constructed
+// inventory plugin, which is JSON and so also YAML, to name.
+func (p *Provisioner) writeConstructedInventory(name string) error {
+	config := map[string]interface{}{"plugin": "constructed"}
+	for k, v := range p.config.ConstructedInventory {
+		config[k] = v
+	}
+	b, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, append(b, '\n'), 0644)
+}
+
+// hostVarsMap returns the variables of the machine as a map, for inventories
+// that are JSON. packer_user_variables is an object rather than a string.
+func (p *Provisioner) hostVarsMap() map[string]interface{} {
+	vars := make(map[string]interface{})
+	for _, v := range p.hostVars() {
+		vars[v.name] = v.value
+	}
+	if _, ok := vars["packer_user_variables"]; ok {
+		user := p.config.PackerUserVars
+		if user == nil {
+			user = map[string]string{}
+		}
+		vars["packer_user_variables"] = user
+	}
+	return vars
+}
+
+// validateInventoryGroup checks that name is a valid group that is in the
+// generated inventory. Any group is accepted when there is an inventory_file,
+// which may define it.
+func (p *Provisioner) validateInventoryGroup(name, option string) error {
+	if err := validateGroupName(name, option); err != nil {
+		return err
+	}
+	if name == "all" || name == "ungrouped" || len(p.config.InventoryFile) > 0 {
+		return nil
+	}
+	for _, groups := range [][]string{p.config.Groups, p.config.EmptyGroups} {
+		for _, group := range groups {
+			if group == name {
+				return nil
+			}
+		}
+	}
+	for parent, children := range p.config.GroupChildren {
+		if parent == name {
+			return nil
+		}
+		for _, child := range children {
+			if child == name {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%s: %s is not a group of the inventory", option, name)
+}
+
+// writeInventoryVars writes group_variables and host_variables as JSON files in
+// the group_vars and host_vars directories of dir, where ansible finds them
+// for an inventory in dir.
+func (p *Provisioner) writeInventoryVars(dir string) error {
+	for group, vars := range p.config.GroupVariables {
+		if err := writeVarsFile(filepath.Join(dir, "group_vars"), group, vars); err != nil {
+			return err
+		}
+	}
+	if len(p.config.HostVariables) > 0 {
+		if err := writeVarsFile(filepath.Join(dir, "host_vars"), p.config.HostAlias, p.config.HostVariables); err != nil {
+			return err
+		}
+	}
+	for alias, vars := range p.config.HostAliases {
+		if len(vars) == 0 {
+			continue
+		}
+		if err := writeVarsFile(filepath.Join(dir, "host_vars"), alias, vars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hosts returns the names of the machine in the inventory: host_alias
+// followed by the host_aliases in order.
+func (p *Provisioner) hosts() []string {
+	hosts := []string{p.config.HostAlias}
+	aliases := make([]string, 0, len(p.config.HostAliases))
+	for alias := range p.config.HostAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return append(hosts, aliases...)
+}
+
+// hasInventoryVars reports whether any variables are to be written to
+// group_vars or host_vars files.
+func (p *Provisioner) hasInventoryVars() bool {
+	if len(p.config.GroupVariables) > 0 || len(p.config.HostVariables) > 0 {
+		return true
+	}
+	for _, vars := range p.config.HostAliases {
+		if len(vars) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func writeVarsFile(dir, name string, vars map[string]interface{}) error {
+	b, err := json.MarshalIndent(vars, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, name+".json"), append(b, '\n'), 0644)
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validateGroupName checks that name can be used as a group in an INI
+// inventory.
+func validateGroupName(name, option string) error {
+	if name == "" || strings.ContainsAny(name, " \t[]:=#;") {
+		return fmt.Errorf("%s: %q is not a valid group name", option, name)
+	}
+	return nil
+}
+
+// hostVars returns the variables of the machine in the inventory.
+func (p *Provisioner) hostVars() []variable {
+	host, port := p.target()
+	user := p.targetUser()
+	if len(p.config.InventoryUser) > 0 {
+		user = p.config.InventoryUser
+	}
+	if len(p.config.InventoryPort) > 0 {
+		port = p.config.InventoryPort
+	}
+	var vars []variable
+	switch {
+	case p.config.Connection == "winrm":
+		vars = p.winrmVars(host, port, user)
+	case p.isContainerConnection():
+		vars = p.containerVars()
+	case p.config.Connection == "local":
+		vars = []variable{{"ansible_connection", "local"}}
+	default:
+		vars = []variable{
+			{"ansible_ssh_host", host},
+			{"ansible_ssh_user", user},
+			{"ansible_ssh_port", port},
+		}
+		if len(p.config.SSHPrivateKeyFile) > 0 {
+			key, _ := filepath.Abs(p.config.SSHPrivateKeyFile)
+			vars = append(vars, variable{"ansible_ssh_private_key_file", p.controllerPath(key)})
+		}
+		if command := p.bastionCommand(); command != "" {
+			vars = append(vars, variable{"ansible_ssh_common_args", "-o ProxyCommand=" + shellQuote(command)})
+		}
+	}
+	vars = append(vars, p.becomeVars()...)
+	if len(p.config.PythonInterpreter) > 0 {
+		vars = append(vars, variable{"ansible_python_interpreter", p.config.PythonInterpreter})
+	}
+	if len(p.config.ShellType) > 0 {
+		vars = append(vars, variable{"ansible_shell_type", p.config.ShellType})
+	} else if p.config.GuestOSType == "windows" && p.config.Connection != "winrm" {
+		vars = append(vars, variable{"ansible_shell_type", "powershell"})
+	}
+	if len(p.config.ShellExecutable) > 0 {
+		vars = append(vars, variable{"ansible_shell_executable", p.config.ShellExecutable})
+	}
+	if p.config.PackerHostVars {
+		vars = append(vars, p.packerVars()...)
+	}
+	return vars
+}
+
+// packerVars returns variables that describe the build.
+func (p *Provisioner) packerVars() []variable {
+	version := packer.Version
+	if packer.VersionPrerelease != "" {
+		version += "-" + packer.VersionPrerelease
+	}
+
+	user := p.config.PackerUserVars
+	if user == nil {
+		user = map[string]string{}
+	}
+	userVars, _ := json.Marshal(user)
+
+	return []variable{
+		{"packer_build_name", p.config.PackerBuildName},
+		{"packer_builder_type", p.config.PackerBuilderType},
+		{"packer_version", version},
+		{"packer_user_variables", string(userVars)},
+	}
+}
@@ -0,0 +1,586 @@
+package ansible
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProvisioner_WriteInventory(t *testing.T) {
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.LocalPort = "2222"
+	p.config.PackerBuildName = "amazon-ebs"
+	p.config.PackerBuilderType = "amazon-ebs"
+	p.config.PackerUserVars = map[string]string{"region": "us-east-1", "name": "it's"}
+
+	var b bytes.Buffer
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if strings.Contains(b.String(), "packer_") {
+		t.Fatalf("expected no packer variables unless packer_host_vars is set: %s", b.String())
+	}
+
+	p.config.PackerHostVars = true
+	b.Reset()
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	inv := b.String()
+	for _, expected := range []string{
+		"default ansible_ssh_host=127.0.0.1 ansible_ssh_user=packer-ansible ansible_ssh_port=2222 ",
+		" packer_build_name=amazon-ebs ",
+		" packer_version=",
+		` packer_user_variables='{"name":"it'\''s","region":"us-east-1"}'`,
+	} {
+		if !strings.Contains(inv, expected) {
+			t.Fatalf("expected %s in inventory: %s", expected, inv)
+		}
+	}
+
+}
+
+func TestProvisioner_WriteInventoryGroups(t *testing.T) {
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.LocalPort = "2222"
+	p.config.Groups = []string{"webservers", "production"}
+	p.config.EmptyGroups = []string{"dbservers"}
+	p.config.GroupChildren = map[string][]string{
+		"production":  {"webservers", "dbservers"},
+		"all_servers": {"production"},
+	}
+
+	var b bytes.Buffer
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := `default ansible_ssh_host=127.0.0.1 ansible_ssh_user=packer-ansible ansible_ssh_port=2222
+
+[webservers]
+default
+
+[production]
+default
+
+[dbservers]
+
+[all_servers:children]
+production
+
+[production:children]
+webservers
+dbservers
+`
+	if b.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+
+	if err := validateGroupName("web servers", "groups"); err == nil {
+		t.Fatal("should error for a group name with a space")
+	}
+}
+
+func TestProvisioner_WriteInventoryHostAlias(t *testing.T) {
+	var p Provisioner
+	p.config.HostAlias = "web01"
+	p.config.LocalPort = "2222"
+	p.config.Groups = []string{"webservers"}
+
+	var b bytes.Buffer
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.HasPrefix(b.String(), "web01 ansible_ssh_host=127.0.0.1 ") || !strings.Contains(b.String(), "[webservers]\nweb01\n") {
+		t.Fatalf("unexpected inventory:\n%s", b.String())
+	}
+}
+
+func TestProvisioner_WriteInventoryVars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var p Provisioner
+	p.config.HostAlias = "web01"
+	p.config.GroupVariables = map[string]map[string]interface{}{
+		"webservers": {"http_port": 80, "vhosts": []interface{}{"a", "b"}},
+	}
+	p.config.HostVariables = map[string]interface{}{"role": "frontend"}
+
+	if err := p.writeInventoryVars(dir); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for name, expected := range map[string]string{
+		"group_vars/webservers.json": `"http_port": 80`,
+		"host_vars/web01.json":       `"role": "frontend"`,
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !strings.Contains(string(b), expected) {
+			t.Fatalf("expected %s in %s:\n%s", expected, name, b)
+		}
+	}
+}
+
+func TestProvisioner_WriteYAMLInventory(t *testing.T) {
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.LocalPort = "2222"
+	p.config.PackerUserVars = map[string]string{"region": "us-east-1"}
+	p.config.PackerHostVars = true
+	p.config.Groups = []string{"webservers"}
+	p.config.EmptyGroups = []string{"dbservers"}
+	p.config.GroupChildren = map[string][]string{"production": {"webservers"}}
+
+	var b bytes.Buffer
+	if err := p.writeYAMLInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var inv struct {
+		All struct {
+			Hosts    map[string]map[string]interface{}
+			Children map[string]struct {
+				Hosts    map[string]interface{}
+				Children map[string]interface{}
+			}
+		}
+	}
+	if err := json.Unmarshal(b.Bytes(), &inv); err != nil {
+		t.Fatalf("err: %s\n%s", err, b.String())
+	}
+	host := inv.All.Hosts["default"]
+	if host["ansible_ssh_port"] != "2222" {
+		t.Fatalf("unexpected host variables: %v", host)
+	}
+	if user, ok := host["packer_user_variables"].(map[string]interface{}); !ok || user["region"] != "us-east-1" {
+		t.Fatalf("expected packer_user_variables to be an object: %v", host["packer_user_variables"])
+	}
+	if _, ok := inv.All.Children["webservers"].Hosts["default"]; !ok {
+		t.Fatalf("expected default in webservers:\n%s", b.String())
+	}
+	if _, ok := inv.All.Children["dbservers"]; !ok {
+		t.Fatalf("expected dbservers:\n%s", b.String())
+	}
+	if _, ok := inv.All.Children["production"].Children["webservers"]; !ok {
+		t.Fatalf("expected webservers in production:\n%s", b.String())
+	}
+}
+
+func TestProvisioner_WriteScriptInventory(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.LocalPort = "2222"
+	p.config.InventoryFormat = "script"
+	p.config.Groups = []string{"webservers"}
+	p.config.GroupChildren = map[string][]string{"production": {"webservers"}}
+
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	p.config.StagingDir = dir
+
+	if err := p.generateInventory(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	out, err := exec.Command(p.config.inventoryFile, "--list").Output()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var inv struct {
+		Production struct{ Children []string }
+		Webservers struct{ Hosts []string }
+		Meta       struct {
+			Hostvars map[string]map[string]interface{}
+		} `json:"_meta"`
+	}
+	if err := json.Unmarshal(out, &inv); err != nil {
+		t.Fatalf("err: %s\n%s", err, out)
+	}
+	if len(inv.Webservers.Hosts) != 1 || inv.Webservers.Hosts[0] != "default" {
+		t.Fatalf("expected default in webservers:\n%s", out)
+	}
+	if len(inv.Production.Children) != 1 || inv.Production.Children[0] != "webservers" {
+		t.Fatalf("expected webservers in production:\n%s", out)
+	}
+	if inv.Meta.Hostvars["default"]["ansible_ssh_port"] != "2222" {
+		t.Fatalf("unexpected host variables:\n%s", out)
+	}
+
+	out, err = exec.Command(p.config.inventoryFile, "--host", "default").Output()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if strings.TrimSpace(string(out)) != "{}" {
+		t.Fatalf("unexpected --host output: %s", out)
+	}
+}
+
+func TestProvisioner_InventoryFileTemplate(t *testing.T) {
+	if _, err := parseInventoryTemplate("{{.Hostname}}"); err == nil {
+		t.Fatal("should error for an unknown field")
+	}
+
+	var p Provisioner
+	p.config.HostAlias = "web"
+	p.config.LocalPort = "2222"
+	p.config.SSHPrivateKeyFile = "/key"
+
+	var err error
+	p.config.inventoryTemplate, err = parseInventoryTemplate(
+		"{{.HostAlias}} ansible_host={{.Address}} ansible_port={{.Port}} ansible_user={{.User}} ansible_ssh_private_key_file={{.KeyFile}}\n")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	p.config.StagingDir = dir
+
+	if err := p.generateInventory(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	b, err := ioutil.ReadFile(p.config.inventoryFile)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := "web ansible_host=127.0.0.1 ansible_port=2222 ansible_user=packer-ansible ansible_ssh_private_key_file=/key\n"
+	if string(b) != expected {
+		t.Fatalf("expected %q, got %q", expected, b)
+	}
+}
+
+func TestProvisioner_InventoryFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	original := "[webservers]\nweb1\n"
+	inventory := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(inventory, []byte(original), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "group_vars"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.LocalPort = "2222"
+	p.config.StagingDir = dir
+	p.config.InventoryFile = inventory
+	p.config.Groups = []string{"webservers"}
+
+	if err := p.generateInventory(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, name := range []string{"hosts", "group_vars"} {
+		target, err := os.Readlink(filepath.Join(p.config.inventoryFile, name))
+		if err != nil || target != filepath.Join(dir, name) {
+			t.Fatalf("expected %s to link to %s, got %s (%v)", name, filepath.Join(dir, name), target, err)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(p.config.inventoryFile, "packer-provisioner-ansible"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.HasPrefix(string(b), "default ansible_ssh_host=127.0.0.1") || !strings.Contains(string(b), "[webservers]\ndefault") {
+		t.Fatalf("expected the machine in the generated inventory:\n%s", b)
+	}
+	if b, _ := ioutil.ReadFile(inventory); string(b) != original {
+		t.Fatalf("expected the inventory_file to be untouched, got:\n%s", b)
+	}
+
+	generated := p.config.inventoryFile
+	p.cleanup()
+	if _, err := os.Stat(generated); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed", generated)
+	}
+	if b, _ := ioutil.ReadFile(inventory); string(b) != original {
+		t.Fatalf("expected the inventory_file to be kept, got:\n%s", b)
+	}
+}
+
+func TestProvisioner_InventoryFileDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	inventory := filepath.Join(dir, "inventory")
+	for _, sub := range []string{"group_vars", "host_vars"} {
+		if err := os.MkdirAll(filepath.Join(inventory, sub), 0755); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(inventory, "hosts.yml"), nil, 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.StagingDir = dir
+	p.config.InventoryFile = inventory
+	p.config.InventoryFormat = "yaml"
+
+	if err := p.generateInventory(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, name := range []string{"group_vars", "host_vars", "hosts.yml"} {
+		if target, err := os.Readlink(filepath.Join(p.config.inventoryFile, name)); err != nil || target != filepath.Join(inventory, name) {
+			t.Fatalf("expected %s to link to %s, got %s (%v)", name, filepath.Join(inventory, name), target, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(p.config.inventoryFile, "packer-provisioner-ansible.yml")); err != nil {
+		t.Fatalf("expected the generated inventory: %s", err)
+	}
+
+	p.cleanup()
+	entries, _ := ioutil.ReadDir(inventory)
+	if len(entries) != 3 {
+		t.Fatalf("expected the inventory_file to be untouched, got %d entries", len(entries))
+	}
+}
+
+func TestProvisioner_KeepInventoryFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.StagingDir = dir
+	p.config.KeepInventoryFile = true
+
+	if err := p.generateInventory(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(p.keptInventory) == 0 {
+		t.Fatal("expected the inventory to be kept")
+	}
+
+	p.cleanup()
+	if _, err := os.Stat(p.config.inventoryFile); err != nil {
+		t.Fatalf("expected the inventory to be kept: %s", err)
+	}
+}
+
+func TestProvisioner_InventoryUserPort(t *testing.T) {
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.LocalPort = "2222"
+	p.config.InventoryUser = "deploy"
+	p.config.InventoryPort = "2200"
+
+	var b bytes.Buffer
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := "default ansible_ssh_host=127.0.0.1 ansible_ssh_user=deploy ansible_ssh_port=2200"
+	if !strings.HasPrefix(b.String(), expected) {
+		t.Fatalf("expected %q, got %q", expected, b.String())
+	}
+}
+
+func TestProvisioner_ConnectionVars(t *testing.T) {
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.PythonInterpreter = "/usr/bin/python3"
+	p.config.ShellType = "csh"
+	p.config.ShellExecutable = "/bin/tcsh"
+
+	var b bytes.Buffer
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, v := range []string{
+		" ansible_python_interpreter=/usr/bin/python3",
+		" ansible_shell_type=csh",
+		" ansible_shell_executable=/bin/tcsh",
+	} {
+		if !strings.Contains(b.String(), v) {
+			t.Fatalf("expected %q in %q", v, b.String())
+		}
+	}
+}
+
+func TestProvisioner_HostAliases(t *testing.T) {
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.LocalPort = "2222"
+	p.config.Groups = []string{"app"}
+	p.config.HostAliases = map[string]map[string]interface{}{
+		"web": {"http_port": 8080},
+		"db":  {},
+	}
+
+	var b bytes.Buffer
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	vars := " ansible_ssh_host=127.0.0.1 ansible_ssh_user=packer-ansible ansible_ssh_port=2222\n"
+	expected := "default" + vars + "db" + vars + "web" + vars + "\n[app]\ndefault\ndb\nweb\n"
+	if b.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := p.writeInventoryVars(dir); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "host_vars", "web.json")); err != nil {
+		t.Fatalf("expected variables of web: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "host_vars", "db.json")); !os.IsNotExist(err) {
+		t.Fatalf("expected no variables of db: %v", err)
+	}
+}
+
+func TestProvisioner_InventoryGroupVars(t *testing.T) {
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.Groups = []string{"webservers"}
+	p.config.InventoryGroupVars = map[string]map[string]string{
+		"webservers": {"http_port": "8080", "env": "staging"},
+	}
+
+	if err := p.validateInventoryGroup("webservers", "inventory_group_vars"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := p.validateInventoryGroup("dbservers", "inventory_group_vars"); err == nil {
+		t.Fatal("should error for a group that is not in the inventory")
+	}
+
+	var b bytes.Buffer
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.HasSuffix(b.String(), "\n[webservers:vars]\nenv=staging\nhttp_port=8080\n") {
+		t.Fatalf("expected a [webservers:vars] section, got:\n%s", b.String())
+	}
+}
+
+func TestProvisioner_ConstructedInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.StagingDir = dir
+	p.config.ConstructedInventory = map[string]interface{}{
+		"keyed_groups": []interface{}{
+			map[string]interface{}{"key": "packer_builder_type", "prefix": "builder"},
+		},
+	}
+
+	if err := p.generateInventory(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if filepath.Base(p.config.inventoryFile) == "hosts" {
+		t.Fatalf("expected the inventory to be a directory, got %s", p.config.inventoryFile)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(p.config.inventoryFile, "hosts_constructed.yml"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(b, &config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if config["plugin"] != "constructed" || config["keyed_groups"] == nil {
+		t.Fatalf("unexpected constructed inventory:\n%s", b)
+	}
+	if _, err := os.Stat(filepath.Join(p.config.inventoryFile, "hosts")); err != nil {
+		t.Fatalf("expected the hosts next to the constructed inventory: %s", err)
+	}
+}
+
+func TestProvisioner_Bastion(t *testing.T) {
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.UseProxy = new(bool)
+	p.config.SSHHost = "10.0.0.5"
+	p.config.SSHPort = "22"
+	p.config.SSHUsername = "ubuntu"
+	p.config.BastionHost = "bastion.example.com"
+	p.config.BastionPort = "2200"
+	p.config.BastionUser = "jump"
+	p.config.BastionPrivateKeyFile = "/bastion_key"
+
+	command := "ssh -W %h:%p -p 2200 -i /bastion_key jump@bastion.example.com"
+	if c := p.bastionCommand(); c != command {
+		t.Fatalf("expected %q, got %q", command, c)
+	}
+
+	var b bytes.Buffer
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := "ansible_ssh_common_args=" + shellQuote("-o ProxyCommand='"+command+"'")
+	if !strings.Contains(b.String(), expected) {
+		t.Fatalf("expected %q in %q", expected, b.String())
+	}
+
+	b.Reset()
+	if err := p.writeSSHConfig(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(b.String(), "  ProxyCommand "+command+"\n") {
+		t.Fatalf("expected a ProxyCommand in:\n%s", b.String())
+	}
+}
+
+func TestProvisioner_WindowsShellType(t *testing.T) {
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.GuestOSType = "windows"
+
+	var b bytes.Buffer
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(b.String(), " ansible_shell_type=powershell") {
+		t.Fatalf("expected ansible_shell_type=powershell, got %q", b.String())
+	}
+
+	p.config.ShellType = "cmd"
+	b.Reset()
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(b.String(), " ansible_shell_type=cmd") || strings.Contains(b.String(), "powershell") {
+		t.Fatalf("expected ansible_shell_type=cmd, got %q", b.String())
+	}
+}
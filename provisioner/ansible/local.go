@@ -0,0 +1,112 @@
+package ansible
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// prepareLocal checks the options of the local mode.
+func (p *Provisioner) prepareLocal() []error {
+	var errs []error
+	switch p.config.Mode {
+	case "":
+		p.config.Mode = "proxy"
+	case "proxy", "local":
+	default:
+		errs = append(errs, fmt.Errorf("mode: %s must be one of proxy or local", p.config.Mode))
+	}
+	if p.config.Mode == "local" && p.config.Connection != "local" {
+		errs = append(errs, fmt.Errorf("connection: %s cannot be used with the local mode", p.config.Connection))
+	}
+	if p.config.Mode != "local" && p.config.Connection == "local" {
+		errs = append(errs, errors.New("connection: local can only be used with the local mode"))
+	}
+	if p.config.Mode == "local" && p.config.PullRepo != "" {
+		errs = append(errs, errors.New("pull_repo cannot be used with the local mode"))
+	}
+	return errs
+}
+
+// uploadLocal uploads the inventory, with the group_variables and
+// host_variables, and the roles and collections directories to the remote
+// staging directory for the local mode. It returns the path of the uploaded
+// inventory and the environment overrides that point ansible at the uploaded
+// directories.
+func (p *Provisioner) uploadLocal(ui packer.Ui, comm packer.Communicator) (string, []string, error) {
+	staging := p.config.RemoteStagingDir
+
+	dir, err := p.tempDir("local")
+	if err != nil {
+		return "", nil, fmt.Errorf("Error preparing inventory file: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := p.writeInventoryVars(dir); err != nil {
+		return "", nil, fmt.Errorf("Error preparing inventory file: %s", err)
+	}
+	f, err := os.Create(filepath.Join(dir, "hosts"))
+	if err != nil {
+		return "", nil, fmt.Errorf("Error preparing inventory file: %s", err)
+	}
+	err = p.writeInventory(f)
+	f.Close()
+	if err != nil {
+		return "", nil, fmt.Errorf("Error preparing inventory file: %s", err)
+	}
+
+	uploads := []struct{ src, dst string }{{dir, path.Join(staging, "inventory")}}
+	var env []string
+	for _, paths := range []struct {
+		name     string
+		variable string
+		dirs     []string
+	}{
+		{"roles", "ANSIBLE_ROLES_PATH", p.rolesPaths()},
+		{"collections", "ANSIBLE_COLLECTIONS_PATHS", p.collectionsPaths()},
+	} {
+		var remote []string
+		for i, src := range paths.dirs {
+			dst := path.Join(staging, paths.name, strconv.Itoa(i))
+			uploads = append(uploads, struct{ src, dst string }{src, dst})
+			remote = append(remote, dst)
+		}
+		if len(remote) > 0 {
+			env = append(env, shellQuote(paths.variable+"="+strings.Join(remote, ":")))
+		}
+	}
+
+	mkdir := []string{"mkdir", "-p"}
+	for _, u := range uploads {
+		mkdir = append(mkdir, shellQuote(u.dst))
+	}
+	if err := p.runRemote(ui, comm, strings.Join(mkdir, " ")); err != nil {
+		return "", nil, fmt.Errorf("Error creating %s: %s", staging, err)
+	}
+	for _, u := range uploads {
+		ui.Say(fmt.Sprintf("Uploading %s to %s", u.src, u.dst))
+		// A trailing slash uploads the contents of the directory rather than
+		// the directory itself.
+		src := filepath.Clean(u.src) + string(filepath.Separator)
+		if err := comm.UploadDir(u.dst, src, nil); err != nil {
+			return "", nil, fmt.Errorf("Error uploading %s: %s", u.src, err)
+		}
+	}
+
+	return path.Join(staging, "inventory", "hosts"), env, nil
+}
+
+// removeLocalInventory removes the inventory that uploadLocal uploaded, which
+// holds the variables of the machine, even when the rest of the remote
+// staging directory is kept.
+func (p *Provisioner) removeLocalInventory(ui packer.Ui, comm packer.Communicator) {
+	dir := path.Join(p.config.RemoteStagingDir, "inventory")
+	if err := p.runRemote(ui, comm, "rm -rf "+shellQuote(dir)); err != nil {
+		ui.Error(fmt.Sprintf("Error removing %s: %s", dir, err))
+	}
+}
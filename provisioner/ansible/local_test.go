@@ -0,0 +1,122 @@
+package ansible
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// uploadCommunicator records the files and directories that are uploaded,
+// along with the inventory in them, and the commands, which all succeed.
+type uploadCommunicator struct {
+	communicator
+	uploads  map[string]string
+	commands *[]string
+}
+
+func (c uploadCommunicator) Start(cmd *packer.RemoteCmd) error {
+	if c.commands != nil {
+		*c.commands = append(*c.commands, cmd.Command)
+	}
+	cmd.SetExited(0)
+	return nil
+}
+
+func (c uploadCommunicator) Upload(dst string, src io.Reader, fi *os.FileInfo) error {
+	b, _ := ioutil.ReadAll(src)
+	c.uploads[dst] = string(b)
+	return nil
+}
+
+func (c uploadCommunicator) UploadDir(dst string, src string, exclude []string) error {
+	b, _ := ioutil.ReadFile(filepath.Join(src, "hosts"))
+	c.uploads[dst] = string(b)
+	return nil
+}
+
+func TestProvisionerPrepare_LocalMode(t *testing.T) {
+	var p Provisioner
+	config := testConfig()
+
+	playbook_file, err := ioutil.TempFile("", "playbook")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(playbook_file.Name())
+
+	config["playbook_file"] = playbook_file.Name()
+	config["mode"] = "local"
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should error without playbook_dir")
+	}
+
+	config["playbook_dir"] = filepath.Dir(playbook_file.Name())
+	p = Provisioner{}
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !p.config.RemoteExecution || p.config.Connection != "local" {
+		t.Fatalf("expected the local mode to execute remotely with the local connection, got %s", p.config.Connection)
+	}
+
+	config["connection"] = "docker"
+	p = Provisioner{}
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should error with the docker connection")
+	}
+
+	config["mode"] = "remote"
+	p = Provisioner{}
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should error with an unknown mode")
+	}
+}
+
+func TestProvisioner_UploadLocal(t *testing.T) {
+	roles, err := ioutil.TempDir("", "roles")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(roles)
+
+	var p Provisioner
+	p.config.Mode = "local"
+	p.config.Connection = "local"
+	p.config.HostAlias = "default"
+	p.config.Groups = []string{"web"}
+	p.config.RemoteStagingDir = "/tmp/packer-provisioner-ansible"
+	p.config.RolesPath = []string{roles}
+
+	comm := uploadCommunicator{uploads: make(map[string]string)}
+	inventory, env, err := p.uploadLocal(new(ui), comm)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if inventory != "/tmp/packer-provisioner-ansible/inventory/hosts" {
+		t.Fatalf("unexpected inventory %s", inventory)
+	}
+	if expected := []string{"ANSIBLE_ROLES_PATH=/tmp/packer-provisioner-ansible/roles/0"}; !reflect.DeepEqual(env, expected) {
+		t.Fatalf("expected %v, got %v", expected, env)
+	}
+
+	hosts, ok := comm.uploads["/tmp/packer-provisioner-ansible/inventory"]
+	if !ok || !strings.HasPrefix(hosts, "default ansible_connection=local\n") || !strings.Contains(hosts, "[web]\ndefault\n") {
+		t.Fatalf("unexpected inventory %q", hosts)
+	}
+	if _, ok := comm.uploads["/tmp/packer-provisioner-ansible/roles/0"]; !ok {
+		t.Fatalf("expected the roles to be uploaded, got %v", comm.uploads)
+	}
+
+	var commands []string
+	comm.commands = &commands
+	p.removeLocalInventory(new(ui), comm)
+	if expected := []string{"rm -rf /tmp/packer-provisioner-ansible/inventory"}; !reflect.DeepEqual(commands, expected) {
+		t.Fatalf("expected %v, got %v", expected, commands)
+	}
+}
@@ -0,0 +1,97 @@
+package ansible
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// versionPattern matches the version in the output of ansible-playbook
+// --version, e.g. "ansible-playbook 2.0.0.2" or "ansible-playbook [core 2.11.1]".
+var versionPattern = regexp.MustCompile(`^\S+ \[?(?:core )?(\d+\.\d+[0-9A-Za-z.]*)`)
+
+// buildMetadata records how the machine was provisioned.
+type buildMetadata struct {
+	AnsibleVersion string             `json:"ansible_version"`
+	Inventory      string             `json:"inventory"`
+	Playbooks      []playbookMetadata `json:"playbooks"`
+	LogFile        string             `json:"log_file,omitempty"`
+}
+
+type playbookMetadata struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// metadata returns the metadata of the run. Values that cannot be determined
+// are left empty.
+func (p *Provisioner) metadata() *buildMetadata {
+	m := &buildMetadata{
+		AnsibleVersion: p.ansibleVersion(),
+		Inventory:      p.config.inventoryFile,
+		Playbooks:      []playbookMetadata{},
+	}
+	if p.config.LogFile != "" {
+		m.LogFile, _ = filepath.Abs(p.config.LogFile)
+	}
+	for _, playbook := range p.playbooks() {
+		path, _ := filepath.Abs(playbook)
+		sum, err := fileSHA256(path)
+		if err != nil {
+			log.Printf("Error hashing %s: %s", path, err)
+		}
+		m.Playbooks = append(m.Playbooks, playbookMetadata{Path: path, SHA256: sum})
+	}
+	return m
+}
+
+// report reports the metadata as machine-readable events.
+func (m *buildMetadata) report(ui packer.Ui) {
+	ui.Machine("ansible-metadata", "ansible_version", m.AnsibleVersion)
+	ui.Machine("ansible-metadata", "inventory", m.Inventory)
+	for _, playbook := range m.Playbooks {
+		ui.Machine("ansible-metadata", "playbook", playbook.Path, playbook.SHA256)
+	}
+	if m.LogFile != "" {
+		ui.Machine("ansible-metadata", "log_file", m.LogFile)
+	}
+}
+
+// ansibleVersion returns the version of ansible-playbook, or an empty string
+// when it cannot be determined.
+func (p *Provisioner) ansibleVersion() string {
+	cmd := p.command("", p.config.Command, "--version")
+	out, err := cmd.Output()
+	if err != nil {
+		log.Printf("Error determining the version of %s: %s", p.config.Command, err)
+		return ""
+	}
+	return parseVersion(string(out))
+}
+
+func parseVersion(out string) string {
+	if m := versionPattern.FindStringSubmatch(out); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,16 @@
+package ansible
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	for out, expected := range map[string]string{
+		"ansible-playbook 2.0.0.2\n  config file = \n":            "2.0.0.2",
+		"ansible-playbook [core 2.11.1] \n  config file = None\n": "2.11.1",
+		"ansible-playbook 2.1.0 (devel 1a2b3c) last updated":      "2.1.0",
+		"command not found": "",
+	} {
+		if v := parseVersion(out); v != expected {
+			t.Fatalf("expected %q for %q, got %q", expected, out, v)
+		}
+	}
+}
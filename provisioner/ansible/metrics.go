@@ -0,0 +1,69 @@
+package ansible
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// metricsTimeout limits how long sending the usage metrics may delay the
+// build.
+const metricsTimeout = 5 * time.Second
+
+// usageMetrics are the anonymous usage metrics of a run. They include nothing
+// that identifies the build, such as names, paths, or variables.
+type usageMetrics struct {
+	Time           time.Time `json:"time"`
+	Duration       float64   `json:"duration"`
+	Playbooks      int       `json:"playbooks"`
+	Tasks          int       `json:"tasks"`
+	AnsibleVersion string    `json:"ansible_version"`
+	BuilderType    string    `json:"builder_type"`
+	Succeeded      bool      `json:"succeeded"`
+}
+
+// recordMetrics appends m as a line of JSON to metrics_file and sends it to
+// metrics_endpoint, when they are set. Failures are logged rather than
+// failing the build.
+func (p *Provisioner) recordMetrics(m *usageMetrics) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("Error encoding usage metrics: %s", err)
+		return
+	}
+
+	if p.config.MetricsFile != "" {
+		if err := appendLine(p.config.MetricsFile, b); err != nil {
+			log.Printf("Error writing metrics_file: %s", err)
+		}
+	}
+
+	if p.config.MetricsEndpoint != "" {
+		client := &http.Client{Timeout: metricsTimeout}
+		resp, err := client.Post(p.config.MetricsEndpoint, "application/json", bytes.NewReader(b))
+		if err != nil {
+			log.Printf("Error sending usage metrics: %s", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			log.Printf("Error sending usage metrics: %s", resp.Status)
+		}
+	}
+}
+
+func appendLine(path string, b []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s\n", b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,47 @@
+package ansible
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProvisioner_RecordMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var received usageMetrics
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("err: %s", err)
+		}
+	}))
+	defer server.Close()
+
+	var p Provisioner
+	p.config.MetricsFile = filepath.Join(dir, "metrics.json")
+	p.config.MetricsEndpoint = server.URL
+
+	p.recordMetrics(&usageMetrics{Playbooks: 2, AnsibleVersion: "2.0.0.2", Succeeded: true})
+	p.recordMetrics(&usageMetrics{Playbooks: 1})
+
+	b, err := ioutil.ReadFile(p.config.MetricsFile)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"ansible_version":"2.0.0.2"`) {
+		t.Fatalf("unexpected metrics_file:\n%s", b)
+	}
+	if received.Playbooks != 1 {
+		t.Fatalf("unexpected metrics sent: %+v", received)
+	}
+}
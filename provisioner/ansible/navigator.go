@@ -0,0 +1,72 @@
+package ansible
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// prepareNavigator checks the ansible-navigator options.
+func (p *Provisioner) prepareNavigator() []error {
+	if !p.config.UseNavigator {
+		return nil
+	}
+	var errs []error
+	if p.config.NavigatorCommand == "" {
+		p.config.NavigatorCommand = "ansible-navigator"
+	}
+	if p.config.ExecutionContainer != "" {
+		errs = append(errs, errors.New("use_navigator cannot be used with execution_container"))
+	}
+	switch p.config.ExecutionEnvironmentPullPolicy {
+	case "", "always", "missing", "never", "tag":
+	default:
+		errs = append(errs, fmt.Errorf("execution_environment_pull_policy: %s must be one of always, missing, never, or tag", p.config.ExecutionEnvironmentPullPolicy))
+	}
+	for _, volume := range p.config.ExecutionEnvironmentVolumes {
+		if parts := strings.Split(volume, ":"); len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			errs = append(errs, fmt.Errorf("execution_environment_volumes: %s must be of the form source:destination[:options]", volume))
+		}
+	}
+	return errs
+}
+
+// navigatorArgs returns the arguments of ansible-navigator to run the
+// ansible-playbook arguments args. ansible-navigator passes the arguments it
+// does not know on to ansible-playbook. In an execution environment, the
+// files that ansible uses are mounted at the same paths, the environment
+// overrides are passed through, and the host's network is used so that
+// ansible reaches the SSH proxy.
+func (p *Provisioner) navigatorArgs(args []string) []string {
+	navArgs := []string{"run"}
+	navArgs = append(navArgs, args...)
+	navArgs = append(navArgs, "--mode", "stdout", "--playbook-artifact-enable", "false")
+	if p.config.ExecutionEnvironmentImage == "" {
+		return append(navArgs, "--execution-environment", "false")
+	}
+
+	navArgs = append(navArgs,
+		"--execution-environment", "true",
+		"--execution-environment-image", p.config.ExecutionEnvironmentImage)
+	if p.config.ExecutionEnvironmentPullPolicy != "" {
+		navArgs = append(navArgs, "--pull-policy", p.config.ExecutionEnvironmentPullPolicy)
+	}
+
+	wd := p.config.WorkingDirectory
+	if wd == "" {
+		wd, _ = os.Getwd()
+	}
+	wd, _ = filepath.Abs(wd)
+	for _, mount := range p.executionContainerMounts(wd) {
+		navArgs = append(navArgs, "--execution-environment-volume-mounts", mount+":"+mount)
+	}
+	for _, volume := range p.config.ExecutionEnvironmentVolumes {
+		navArgs = append(navArgs, "--execution-environment-volume-mounts", volume)
+	}
+	for _, name := range envNames(p.env()) {
+		navArgs = append(navArgs, "--pass-environment-variable", name)
+	}
+	return append(navArgs, "--container-options=--net=host")
+}
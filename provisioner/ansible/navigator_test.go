@@ -0,0 +1,64 @@
+package ansible
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProvisioner_Navigator(t *testing.T) {
+	var p Provisioner
+	p.config.UseNavigator = true
+	p.config.PlaybookFile = "/srv/playbook.yml"
+	p.config.inventoryFile = "/srv/hosts"
+	if errs := p.prepareNavigator(); len(errs) > 0 {
+		t.Fatalf("err: %v", errs)
+	}
+
+	cmd := p.ansibleCommand(p.config.PlaybookFile)
+	expected := []string{"ansible-navigator", "run", "/srv/playbook.yml", "-i", "/srv/hosts",
+		"--mode", "stdout", "--playbook-artifact-enable", "false", "--execution-environment", "false"}
+	if !reflect.DeepEqual(cmd.Args, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmd.Args)
+	}
+}
+
+func TestProvisioner_NavigatorExecutionEnvironment(t *testing.T) {
+	var p Provisioner
+	p.config.UseNavigator = true
+	p.config.ExecutionEnvironmentImage = "quay.io/ansible/creator-ee"
+	p.config.ExecutionEnvironmentPullPolicy = "missing"
+	p.config.ExecutionEnvironmentVolumes = []string{"/srv/keys:/keys:Z"}
+	p.config.AnsibleEnvVars = []string{"ANSIBLE_FORKS=1"}
+	if errs := p.prepareNavigator(); len(errs) > 0 {
+		t.Fatalf("err: %v", errs)
+	}
+
+	args := p.navigatorArgs(nil)
+	expected := map[string]string{
+		"--execution-environment":       "true",
+		"--execution-environment-image": "quay.io/ansible/creator-ee",
+		"--pull-policy":                 "missing",
+		"--pass-environment-variable":   "ANSIBLE_FORKS",
+	}
+	for i, arg := range args[:len(args)-1] {
+		if value, ok := expected[arg]; ok && args[i+1] == value {
+			delete(expected, arg)
+		}
+	}
+	if len(expected) > 0 {
+		t.Fatalf("expected %v in %v", expected, args)
+	}
+	if n := len(args); args[n-1] != "--container-options=--net=host" || args[n-6] != "/srv/keys:/keys:Z" {
+		t.Fatalf("unexpected arguments %v", args)
+	}
+}
+
+func TestProvisioner_PrepareNavigator(t *testing.T) {
+	var p Provisioner
+	p.config.UseNavigator = true
+	p.config.ExecutionContainer = "ansible"
+	p.config.ExecutionEnvironmentPullPolicy = "sometimes"
+	if errs := p.prepareNavigator(); len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+}
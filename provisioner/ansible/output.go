@@ -0,0 +1,285 @@
+package ansible
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	playPattern   = regexp.MustCompile(`^PLAY:? \[(.*)\]`)
+	taskPattern   = regexp.MustCompile(`^(TASK|RUNNING HANDLER):? \[(.*)\]`)
+	resultPattern = regexp.MustCompile(`^(ok|changed|skipping|failed|fatal): \[([^\]]+)\](.*)$`)
+	ansiPattern   = regexp.MustCompile("\x1b\\[[0-9;]*[A-Za-z]")
+	recapPattern  = regexp.MustCompile(`^(\S+)\s+:\s+ok=(\d+)\s+changed=(\d+)\s+unreachable=(\d+)\s+failed=(\d+)(?:\s+skipped=(\d+))?`)
+)
+
+// StderrLine handles a line of ansible's stderr.
+func (h *eventHandler) StderrLine(line string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.relay(line)
+}
+
+// relay relays a line of output to the ui, recording and highlighting warnings
+// and deprecation warnings. It is called with mu held.
+func (h *eventHandler) relay(line string) {
+	if h.noColor {
+		line = stripANSI(line)
+	}
+	plain := strings.TrimSpace(stripANSI(line))
+	switch {
+	case strings.HasPrefix(plain, "[DEPRECATION WARNING]"):
+		h.deprecations = append(h.deprecations, plain)
+		h.ui.Error(line)
+	case strings.HasPrefix(plain, "[WARNING]"):
+		h.warnings = append(h.warnings, plain)
+		h.ui.Error(line)
+	default:
+		if !h.quiet {
+			h.ui.Message(line)
+		}
+	}
+}
+
+// TextLine handles a line of ansible's stdout when it is produced by one of
+// ansible's own callback plugins. The line is relayed to the ui, and the tasks,
+// their results, and the recap are recorded as well as they can be
+// recognized. When quiet is set, only failures, warnings, and the recap are
+// relayed.
+func (h *eventHandler) TextLine(line string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	plain := stripANSI(line)
+	h.parseText(plain, time.Now())
+
+	if h.noColor {
+		line = plain
+	}
+
+	if h.quiet {
+		if m := resultPattern.FindStringSubmatch(plain); m != nil && (m[1] == "failed" || m[1] == "fatal") {
+			h.ui.Error(line)
+			return
+		}
+		if h.inRecap {
+			h.ui.Message(line)
+			return
+		}
+	}
+	h.relay(line)
+}
+
+func (h *eventHandler) parseText(line string, now time.Time) {
+	if strings.HasPrefix(line, "PLAY RECAP") {
+		h.inRecap = true
+		return
+	}
+
+	if h.inRecap {
+		if m := recapPattern.FindStringSubmatch(line); m != nil {
+			skipped, _ := strconv.Atoi(m[6])
+			h.stats[m[1]] = h.stats[m[1]].add(hostStats{
+				Ok:          atoi(m[2]),
+				Changed:     atoi(m[3]),
+				Unreachable: atoi(m[4]),
+				Failures:    atoi(m[5]),
+				Skipped:     skipped,
+			})
+			return
+		}
+		if strings.TrimSpace(line) != "" {
+			h.inRecap = false
+		}
+	}
+
+	if m := playPattern.FindStringSubmatch(line); m != nil {
+		h.startPlay(m[1], 0)
+		return
+	}
+
+	if m := taskPattern.FindStringSubmatch(line); m != nil {
+		h.tasks = append(h.tasks, &taskRecord{
+			Playbook: h.playbook,
+			Play:     h.play,
+			Name:     m[2],
+			Start:    now,
+		})
+		if m[1] == "TASK" {
+			h.startTask(m[2])
+		}
+		return
+	}
+
+	if strings.TrimSpace(line) == "...ignoring" {
+		if task := h.task(); task != nil && len(task.Results) > 0 {
+			task.Results[len(task.Results)-1].IgnoreErrors = true
+		}
+		return
+	}
+
+	if m := resultPattern.FindStringSubmatch(line); m != nil {
+		task := h.task()
+		if task == nil {
+			return
+		}
+
+		result := taskResult{Host: strings.SplitN(m[2], " -> ", 2)[0]}
+		switch m[1] {
+		case "ok":
+			result.Status = "ok"
+		case "changed":
+			result.Status, result.Changed = "ok", true
+		case "skipping":
+			result.Status = "skipped"
+		default:
+			result.Status = "failed"
+			if strings.Contains(m[3], "UNREACHABLE!") {
+				result.Status = "unreachable"
+			}
+		}
+
+		if i := strings.Index(m[3], "=> "); i >= 0 {
+			var r struct {
+				Msg    interface{} `json:"msg"`
+				Stderr interface{} `json:"stderr"`
+			}
+			if json.Unmarshal([]byte(m[3][i+len("=> "):]), &r) == nil {
+				result.Msg, result.Stderr = text(r.Msg), text(r.Stderr)
+			}
+		}
+
+		task.End = now
+		task.Results = append(task.Results, result)
+		h.machineResult(task.Name, result)
+	}
+}
+
+// failures describes each failed task result that was not ignored.
+func (h *eventHandler) failures() []string {
+	var failures []string
+	for _, task := range h.tasks {
+		for _, r := range task.Results {
+			if (r.Status != "failed" && r.Status != "unreachable") || r.IgnoreErrors {
+				continue
+			}
+			s := fmt.Sprintf("task %q %s on %s", task.Name, r.Status, r.Host)
+			if task.Action != "" {
+				s = fmt.Sprintf("task %q (%s) %s on %s", task.Name, task.Action, r.Status, r.Host)
+			}
+			if r.Msg != "" {
+				s = fmt.Sprintf("%s: %s", s, r.Msg)
+			}
+			failures = append(failures, s)
+		}
+	}
+	return failures
+}
+
+// totals returns the total of the recap of each host. When no recap has been
+// seen, e.g. because ansible was interrupted, the totals are counted from the
+// task results instead.
+func (h *eventHandler) totals() hostStats {
+	var t hostStats
+	if len(h.stats) > 0 {
+		for _, s := range h.stats {
+			t = t.add(s)
+		}
+		return t
+	}
+
+	for _, task := range h.tasks {
+		for _, r := range task.Results {
+			switch r.Status {
+			case "ok":
+				t.Ok++
+				if r.Changed {
+					t.Changed++
+				}
+			case "failed":
+				if r.IgnoreErrors {
+					t.Ok++
+				} else {
+					t.Failures++
+				}
+			case "unreachable":
+				t.Unreachable++
+			case "skipped":
+				t.Skipped++
+			}
+		}
+	}
+	return t
+}
+
+// summary describes the totals of the run, which took d.
+func (h *eventHandler) summary(d time.Duration) string {
+	t := h.totals()
+	return fmt.Sprintf("Ansible summary: ok=%d changed=%d failed=%d unreachable=%d skipped=%d in %.1fs",
+		t.Ok, t.Changed, t.Failures, t.Unreachable, t.Skipped, d.Seconds())
+}
+
+// slowest returns up to n of the tasks that took the longest, longest first.
+func (h *eventHandler) slowest(n int) []*taskRecord {
+	tasks := make([]*taskRecord, len(h.tasks))
+	copy(tasks, h.tasks)
+	sort.Stable(byDuration(tasks))
+	if len(tasks) > n {
+		tasks = tasks[:n]
+	}
+	return tasks
+}
+
+// byDuration sorts tasks by descending duration.
+type byDuration []*taskRecord
+
+func (s byDuration) Len() int           { return len(s) }
+func (s byDuration) Less(i, j int) bool { return s[i].Duration() > s[j].Duration() }
+func (s byDuration) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// lineLog writes lines of output, without ANSI escape sequences, to w. It is
+// safe for concurrent use.
+type lineLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// tee returns a function that logs each line before calling f with it.
+func (l *lineLog) tee(f func(string)) func(string) {
+	return func(line string) {
+		l.mu.Lock()
+		fmt.Fprintln(l.w, stripANSI(line))
+		l.mu.Unlock()
+		f(line)
+	}
+}
+
+// stripANSI removes ANSI escape sequences from s.
+func stripANSI(s string) string {
+	return ansiPattern.ReplaceAllString(s, "")
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// text returns v, a value decoded from JSON, as a string.
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
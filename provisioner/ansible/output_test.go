@@ -0,0 +1,213 @@
+package ansible
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventHandler_TextLine(t *testing.T) {
+	h := newEventHandler(newUi(new(ui)))
+
+	now := time.Now()
+	for i, line := range []string{
+		"PLAY [all] *********************************************************************",
+		"",
+		"TASK [setup] *******************************************************************",
+		"ok: [default]",
+		"",
+		"TASK [common : install packages] ***********************************************",
+		`fatal: [default]: FAILED! => {"changed": false, "failed": true, "msg": "No package matching 'foo' is available"}`,
+		"",
+		"TASK [optional] ****************************************************************",
+		`fatal: [default]: FAILED! => {"changed": false, "failed": true, "msg": "ignored"}`,
+		"...ignoring",
+		"",
+		"PLAY RECAP *********************************************************************",
+		"default                    : ok=2    changed=0    unreachable=0    failed=1   ",
+	} {
+		h.parseText(line, now.Add(time.Duration(i)*time.Second))
+	}
+
+	if len(h.tasks) != 3 {
+		t.Fatalf("expected 3 tasks, got %d", len(h.tasks))
+	}
+	if h.tasks[1].Name != "common : install packages" || h.tasks[1].Play != "all" {
+		t.Fatalf("unexpected task: %+v", h.tasks[1])
+	}
+	if d := h.tasks[1].Duration(); d != time.Second {
+		t.Fatalf("expected a duration of 1s, got %s", d)
+	}
+
+	failures := h.failures()
+	if len(failures) != 1 {
+		t.Fatalf("expected 1 failure, got %v", failures)
+	}
+	if expected := `task "common : install packages" failed on default: No package matching 'foo' is available`; failures[0] != expected {
+		t.Fatalf("expected %s, got %s", expected, failures[0])
+	}
+
+	if s, ok := h.stats["default"]; !ok || s.Ok != 2 || s.Failures != 1 {
+		t.Fatalf("unexpected stats: %+v", h.stats)
+	}
+}
+
+func TestEventHandler_Totals(t *testing.T) {
+	h := newEventHandler(newUi(new(ui)))
+
+	now := time.Now()
+	for _, line := range []string{
+		"TASK [setup] *******************************************************************",
+		"ok: [default]",
+		"TASK [install packages] ********************************************************",
+		"changed: [default]",
+		"TASK [optional] ****************************************************************",
+		"skipping: [default]",
+	} {
+		h.parseText(line, now)
+	}
+
+	totals := h.totals()
+	if totals.Ok != 2 || totals.Changed != 1 || totals.Skipped != 1 || totals.Failures != 0 {
+		t.Fatalf("unexpected totals without a recap: %+v", totals)
+	}
+
+	h.parseText("PLAY RECAP *********************************************************************", now)
+	h.parseText("default                    : ok=3    changed=1    unreachable=0    failed=0   ", now)
+
+	totals = h.totals()
+	if totals.Ok != 3 || totals.Changed != 1 || totals.Skipped != 0 {
+		t.Fatalf("unexpected totals from the recap: %+v", totals)
+	}
+}
+
+func TestEventHandler_Slowest(t *testing.T) {
+	h := newEventHandler(newUi(new(ui)))
+
+	now := time.Now()
+	for _, task := range []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"fast", time.Second},
+		{"slow", time.Minute},
+		{"medium", 10 * time.Second},
+	} {
+		h.tasks = append(h.tasks, &taskRecord{Name: task.name, Start: now, End: now.Add(task.duration)})
+	}
+
+	slowest := h.slowest(2)
+	if len(slowest) != 2 || slowest[0].Name != "slow" || slowest[1].Name != "medium" {
+		t.Fatalf("unexpected slowest tasks: %v", slowest)
+	}
+	if len(h.slowest(10)) != 3 {
+		t.Fatal("expected all tasks")
+	}
+}
+
+func TestEventHandler_Warnings(t *testing.T) {
+	h := newEventHandler(newUi(new(ui)))
+
+	h.StderrLine(" [WARNING]: Could not match supplied host pattern, ignoring: web")
+	h.StderrLine("\x1b[1;35m[DEPRECATION WARNING]: The sudo command line option has been deprecated.\x1b[0m")
+	h.TextLine("ok: [default]")
+
+	if len(h.warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %v", h.warnings)
+	}
+	if len(h.deprecations) != 1 {
+		t.Fatalf("expected 1 deprecation warning, got %v", h.deprecations)
+	}
+	if expected := "[DEPRECATION WARNING]: The sudo command line option has been deprecated."; h.deprecations[0] != expected {
+		t.Fatalf("expected %q, got %q", expected, h.deprecations[0])
+	}
+}
+
+func TestEventHandler_TextProgress(t *testing.T) {
+	u := new(messageUi)
+	h := newEventHandler(u)
+	h.progress = true
+	for _, line := range []string{
+		"PLAY [all] *********************************************************************",
+		"TASK [setup] *******************************************************************",
+		"RUNNING HANDLER [restart sshd] *************************************************",
+		"PLAY [web] *********************************************************************",
+		"TASK [install packages] ********************************************************",
+	} {
+		h.TextLine(line)
+	}
+	// The number of tasks of a play is unknown from ansible's own output.
+	expected := []string{"task 1: setup", "task 1: install packages"}
+	if !reflect.DeepEqual(u.says, expected) {
+		t.Fatalf("expected %v, got %v", expected, u.says)
+	}
+}
+
+func TestEventHandler_Quiet(t *testing.T) {
+	u := new(messageUi)
+	h := newEventHandler(u)
+	h.quiet = true
+	for _, line := range []string{
+		"PLAY [all] *********************************************************************",
+		"TASK [setup] *******************************************************************",
+		"ok: [default]",
+		`fatal: [default]: FAILED! => {"changed": false, "msg": "No package matching 'foo' is available"}`,
+		"",
+		"PLAY RECAP *********************************************************************",
+		"default                    : ok=1    changed=0    unreachable=0    failed=1   ",
+	} {
+		h.TextLine(line)
+	}
+	h.StderrLine(" [WARNING]: Could not match supplied host pattern, ignoring: web")
+
+	if expected := []string{
+		"PLAY RECAP *********************************************************************",
+		"default                    : ok=1    changed=0    unreachable=0    failed=1   ",
+	}; !reflect.DeepEqual(u.messages, expected) {
+		t.Fatalf("expected only the recap, got %v", u.messages)
+	}
+	if len(u.errors) != 2 || !strings.HasPrefix(u.errors[0], "fatal: [default]") || !strings.Contains(u.errors[1], "[WARNING]") {
+		t.Fatalf("expected the failure and the warning, got %v", u.errors)
+	}
+}
+
+func TestLineLog(t *testing.T) {
+	var b bytes.Buffer
+	l := &lineLog{w: &b}
+
+	var relayed []string
+	stdout := l.tee(func(line string) { relayed = append(relayed, line) })
+	stderr := l.tee(func(string) {})
+	stdout("\x1b[0;32mok: [default]\x1b[0m")
+	stderr(" [WARNING]: Could not match supplied host pattern")
+
+	if expected := "ok: [default]\n [WARNING]: Could not match supplied host pattern\n"; b.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, b.String())
+	}
+	if len(relayed) != 1 || relayed[0] != "\x1b[0;32mok: [default]\x1b[0m" {
+		t.Fatalf("expected the line to be relayed unchanged, got %q", relayed)
+	}
+}
+
+func TestEventHandler_ConcurrentWarnings(t *testing.T) {
+	h := newEventHandler(newUi(new(ui)))
+
+	var wg sync.WaitGroup
+	for _, line := range []func(string){h.TextLine, h.StderrLine} {
+		wg.Add(1)
+		go func(line func(string)) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				line(" [WARNING]: Could not match supplied host pattern")
+			}
+		}(line)
+	}
+	wg.Wait()
+
+	if len(h.warnings) != 200 {
+		t.Fatalf("expected 200 warnings, got %d", len(h.warnings))
+	}
+}
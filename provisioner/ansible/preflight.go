@@ -0,0 +1,121 @@
+package ansible
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// playbookOnlyOptions are the options of ansible-playbook that ansible does
+// not accept, and whether they take a value.
+var playbookOnlyOptions = map[string]bool{
+	"-t":               true,
+	"--tags":           true,
+	"--skip-tags":      true,
+	"--start-at-task":  true,
+	"--step":           false,
+	"--syntax-check":   false,
+	"--list-tasks":     false,
+	"--list-tags":      false,
+	"--flush-cache":    false,
+	"--force-handlers": false,
+}
+
+// adhocArguments returns extra_arguments without the options that only
+// ansible-playbook accepts, so that ad-hoc commands connect the same way that
+// the playbooks do.
+func (p *Provisioner) adhocArguments() []string {
+	var args []string
+	extra := p.renderConnections(p.config.ExtraArguments)
+	for i := 0; i < len(extra); i++ {
+		name := strings.SplitN(extra[i], "=", 2)[0]
+		takesValue, ok := playbookOnlyOptions[name]
+		if !ok {
+			args = append(args, extra[i])
+			continue
+		}
+		if takesValue && name == extra[i] {
+			i++
+		}
+	}
+	return args
+}
+
+// adhocCommand returns the command that runs module against the inventory,
+// with the user variables and the passwords that the playbooks get.
+func (p *Provisioner) adhocCommand(module string, args ...string) *exec.Cmd {
+	cmdArgs := []string{"all", "-i", p.config.inventoryFile, "-m", module}
+	cmdArgs = append(cmdArgs, args...)
+	for _, file := range []string{p.config.userVarsFile, p.config.passwordVarsFile} {
+		if file != "" {
+			cmdArgs = append(cmdArgs, "-e", "@"+file)
+		}
+	}
+	cmdArgs = append(cmdArgs, p.adhocArguments()...)
+
+	return p.command(p.config.WorkingDirectory, p.config.AdhocCommand, cmdArgs...)
+}
+
+// preflight checks that ansible can connect to the machine by running
+// preflight_module, so that connection and authentication
+// problems are reported before any playbook runs. The check is retried up to
+// wait_for_target_retries times, wait_for_target_delay apart.
+func (p *Provisioner) preflight(ui packer.Ui) error {
+	var err error
+	for attempt := 0; attempt <= p.config.WaitForTargetRetries; attempt++ {
+		if attempt > 0 {
+			ui.Say(fmt.Sprintf("The machine is not ready; retrying in %s (%d/%d)",
+				p.config.waitForTargetDelay, attempt, p.config.WaitForTargetRetries))
+			time.Sleep(p.config.waitForTargetDelay)
+		}
+		if err = p.ping(ui, attempt == p.config.WaitForTargetRetries); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// ping runs preflight_module once. The output of a failure is only displayed
+// when verbose is set.
+func (p *Provisioner) ping(ui packer.Ui, verbose bool) error {
+	cmd := p.adhocCommand(p.config.PreflightModule)
+	ui.Say(fmt.Sprintf("Checking the connection: %s", p.redactSecrets(strings.Join(cmd.Args, " "))))
+
+	var (
+		mu     sync.Mutex
+		output []string
+	)
+	record := func(line string) {
+		mu.Lock()
+		output = append(output, line)
+		mu.Unlock()
+	}
+	if err := runCommand(ui, cmd, record, record); err != nil {
+		if verbose {
+			for _, line := range output {
+				ui.Error(line)
+			}
+		}
+		return fmt.Errorf("Ansible could not connect to the machine %s with the %s module: %s", p.connectionPath(), p.config.PreflightModule, err)
+	}
+	return nil
+}
+
+// connectionPath describes how ansible connects to the machine, for errors.
+func (p *Provisioner) connectionPath() string {
+	switch {
+	case p.config.Connection == "winrm":
+		return "over WinRM"
+	case p.isContainerConnection():
+		return fmt.Sprintf("with the %s connection", p.config.Connection)
+	case p.config.Connection == "local":
+		return "with the local connection"
+	case !p.useProxy():
+		return "over SSH"
+	}
+	return "through the SSH proxy"
+}
@@ -0,0 +1,55 @@
+package ansible
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProvisioner_AdhocCommand(t *testing.T) {
+	var p Provisioner
+	p.config.AdhocCommand = "ansible"
+	p.config.inventoryFile = "inventory"
+	p.config.ExtraArguments = []string{"-v", "--tags", "web", "-c", "paramiko", "--skip-tags=db", "--step", "-e", "x=1"}
+
+	cmd := p.adhocCommand("ping")
+	expected := []string{"ansible", "all", "-i", "inventory", "-m", "ping", "-v", "-c", "paramiko", "-e", "x=1"}
+	if !reflect.DeepEqual(cmd.Args, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmd.Args)
+	}
+}
+
+func TestProvisioner_AdhocCommandVars(t *testing.T) {
+	var p Provisioner
+	p.config.AdhocCommand = "ansible"
+	p.config.inventoryFile = "inventory"
+	p.config.userVarsFile = "/tmp/user_vars"
+	p.config.passwordVarsFile = "/tmp/passwords"
+
+	cmd := p.adhocCommand("win_ping")
+	expected := []string{"ansible", "all", "-i", "inventory", "-m", "win_ping", "-e", "@/tmp/user_vars", "-e", "@/tmp/passwords"}
+	if !reflect.DeepEqual(cmd.Args, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmd.Args)
+	}
+}
+
+func TestProvisioner_ConnectionPath(t *testing.T) {
+	useProxy := false
+	for _, tc := range []struct {
+		connection string
+		useProxy   *bool
+		expected   string
+	}{
+		{"ssh", nil, "through the SSH proxy"},
+		{"ssh", &useProxy, "over SSH"},
+		{"winrm", nil, "over WinRM"},
+		{"docker", nil, "with the docker connection"},
+		{"local", nil, "with the local connection"},
+	} {
+		var p Provisioner
+		p.config.Connection = tc.connection
+		p.config.UseProxy = tc.useProxy
+		if got := p.connectionPath(); got != tc.expected {
+			t.Fatalf("%s: expected %q, got %q", tc.connection, tc.expected, got)
+		}
+	}
+}
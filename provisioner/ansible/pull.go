@@ -0,0 +1,78 @@
+package ansible
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// preparePull checks the ansible-pull options.
+func (p *Provisioner) preparePull() []error {
+	if p.config.PullRepo == "" {
+		return nil
+	}
+	var errs []error
+	if p.config.PullCommand == "" {
+		p.config.PullCommand = "ansible-pull"
+	}
+	if p.config.RemoteExecution {
+		errs = append(errs, errors.New("pull_repo cannot be used with remote_execution"))
+	}
+	if strings.HasPrefix(p.config.PullPlaybook, "/") {
+		errs = append(errs, fmt.Errorf("pull_playbook: %s must be relative to the repository", p.config.PullPlaybook))
+	}
+	return errs
+}
+
+// pullCommand returns the command line that runs ansible-pull on the machine.
+// pull_command is a command line, so that it can run ansible-pull with sudo.
+func (p *Provisioner) pullCommand() string {
+	args := []string{"-U", p.config.PullRepo}
+	if p.config.PullCheckout != "" {
+		args = append(args, "-C", p.config.PullCheckout)
+	}
+	if p.config.PullDirectory != "" {
+		args = append(args, "-d", p.config.PullDirectory)
+	}
+	args = append(args, p.playbookOptions(true)...)
+	if p.config.PullPlaybook != "" {
+		args = append(args, p.config.PullPlaybook)
+	}
+
+	var words []string
+	for _, v := range p.gatheringEnv() {
+		words = append(words, shellQuote(v))
+	}
+	words = append(words, p.config.PullCommand)
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// executePull runs pull_install_command, if any, and then ansible-pull on the
+// machine, which checks out pull_repo and runs its playbook against the
+// machine itself.
+func (p *Provisioner) executePull(ui packer.Ui, comm packer.Communicator) error {
+	if p.config.PullInstallCommand != "" {
+		ui.Say(fmt.Sprintf("Installing Ansible on the machine: %s", p.config.PullInstallCommand))
+		if err := p.runRemote(ui, comm, p.config.PullInstallCommand); err != nil {
+			return fmt.Errorf("Error installing Ansible: %s", err)
+		}
+	}
+
+	remove, err := p.uploadVars(ui, comm)
+	if err != nil {
+		return err
+	}
+	defer remove()
+
+	command := p.pullCommand()
+	ui.Say(fmt.Sprintf("Executing ansible-pull on the machine: %s", p.redactSecrets(command)))
+	if err := p.runRemote(ui, comm, command); err != nil {
+		return fmt.Errorf("Error executing ansible-pull: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package ansible
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestProvisionerPrepare_Pull(t *testing.T) {
+	var p Provisioner
+	config := testConfig()
+	config["pull_repo"] = "https://github.com/example/ansible.git"
+
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if p.config.PullCommand != "ansible-pull" {
+		t.Fatalf("expected pull_command to default to ansible-pull, got %s", p.config.PullCommand)
+	}
+
+	config["remote_execution"] = true
+	config["pull_playbook"] = "/site.yml"
+	p = Provisioner{}
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should error with remote_execution and an absolute pull_playbook")
+	}
+}
+
+func TestProvisioner_PullCommand(t *testing.T) {
+	var p Provisioner
+	p.config.PullRepo = "https://github.com/example/ansible.git"
+	p.config.PullCheckout = "release"
+	p.config.PullDirectory = "/var/lib/ansible/local"
+	p.config.PullPlaybook = "site.yml"
+	p.config.PullCommand = "sudo -H ansible-pull"
+	p.config.ExtraArguments = []string{"--tags", "base and web"}
+
+	expected := "sudo -H ansible-pull -U https://github.com/example/ansible.git -C release -d /var/lib/ansible/local --tags 'base and web' site.yml"
+	if command := p.pullCommand(); command != expected {
+		t.Fatalf("expected %q, got %q", expected, command)
+	}
+}
+
+func TestProvisioner_ExecutePullPasswords(t *testing.T) {
+	os.Setenv("PACKER_TEST_BECOME_PASSWORD", "hunter22")
+	defer os.Unsetenv("PACKER_TEST_BECOME_PASSWORD")
+
+	var p Provisioner
+	p.config.PullRepo = "https://github.com/example/ansible.git"
+	p.config.PullCommand = "ansible-pull"
+	p.config.RemoteStagingDir = "/tmp/packer-provisioner-ansible"
+	p.config.BecomePasswordEnv = "PACKER_TEST_BECOME_PASSWORD"
+
+	var commands []string
+	comm := uploadCommunicator{uploads: make(map[string]string), commands: &commands}
+	if err := p.executePull(new(ui), comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	p.cleanup()
+
+	passwords := "/tmp/packer-provisioner-ansible/passwords.json"
+	if !strings.Contains(comm.uploads[passwords], `"ansible_become_password":"hunter22"`) {
+		t.Fatalf("expected the passwords to be uploaded, got %v", comm.uploads)
+	}
+	expected := "ansible-pull -U https://github.com/example/ansible.git -e @" + passwords
+	if len(commands) != 3 || commands[1] != expected || commands[2] != "rm -f "+passwords {
+		t.Fatalf("unexpected commands %v", commands)
+	}
+}
@@ -0,0 +1,189 @@
+package ansible
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// uploadPlaybookDir uploads the contents of playbook_dir to the remote staging
+// directory.
+func (p *Provisioner) uploadPlaybookDir(ui packer.Ui, comm packer.Communicator) error {
+	dir := p.config.RemoteStagingDir
+	ui.Say(fmt.Sprintf("Uploading %s to %s", p.config.PlaybookDir, dir))
+
+	if err := p.runRemote(ui, comm, fmt.Sprintf("mkdir -p %s", shellQuote(dir))); err != nil {
+		return fmt.Errorf("Error creating %s: %s", dir, err)
+	}
+
+	// A trailing slash uploads the contents of the directory rather than the
+	// directory itself.
+	src := filepath.Clean(p.config.PlaybookDir) + string(filepath.Separator)
+	if err := comm.UploadDir(dir, src, nil); err != nil {
+		return fmt.Errorf("Error uploading %s: %s", p.config.PlaybookDir, err)
+	}
+	return nil
+}
+
+// removeRemoteStagingDir removes the remote staging directory.
+func (p *Provisioner) removeRemoteStagingDir(ui packer.Ui, comm packer.Communicator) error {
+	dir := p.config.RemoteStagingDir
+	ui.Say(fmt.Sprintf("Removing %s", dir))
+	if err := p.runRemote(ui, comm, fmt.Sprintf("rm -rf %s", shellQuote(dir))); err != nil {
+		return fmt.Errorf("Error removing %s: %s", dir, err)
+	}
+	return nil
+}
+
+// executeRemote runs each of the playbooks on the machine with a local
+// connection, stopping at the first failure. In the local mode, the playbooks
+// run against the generated inventory, which is uploaded along with the roles
+// and collections.
+func (p *Provisioner) executeRemote(ui packer.Ui, comm packer.Communicator) error {
+	inventory, env := "127.0.0.1,", []string(nil)
+	if p.config.Mode == "local" {
+		var err error
+		inventory, env, err = p.uploadLocal(ui, comm)
+		if err != nil {
+			return err
+		}
+		defer p.removeLocalInventory(ui, comm)
+	}
+	for _, v := range p.gatheringEnv() {
+		env = append(env, shellQuote(v))
+	}
+
+	remove, err := p.uploadVars(ui, comm)
+	if err != nil {
+		return err
+	}
+	defer remove()
+
+	for _, playbook := range p.playbooks() {
+		remote, err := p.remotePlaybook(playbook)
+		if err != nil {
+			return err
+		}
+
+		args := p.ansibleArgs(remote, inventory)
+		if p.config.Mode != "local" {
+			args = append(args, "-c", "local")
+		}
+		words := []string{"cd", shellQuote(p.config.RemoteStagingDir), "&&"}
+		words = append(words, env...)
+		words = append(words, shellQuote(p.config.Command))
+		for _, arg := range args {
+			words = append(words, shellQuote(arg))
+		}
+		command := strings.Join(words, " ")
+
+		ui.Say(fmt.Sprintf("Executing Ansible on the machine: %s", p.redactSecrets(command)))
+		cmd := &packer.RemoteCmd{Command: command}
+		output := &unreachableUi{Ui: ui}
+		if err := cmd.StartWithUi(comm, output); err != nil {
+			return fmt.Errorf("Error executing Ansible: %s", err)
+		}
+		if cmd.ExitStatus != 0 {
+			aerr := newAnsibleError(cmd.ExitStatus, false, nil, output.unreachable(), fmt.Errorf("non-zero exit status: %d", cmd.ExitStatus))
+			aerr.report(ui)
+			return aerr
+		}
+	}
+	return nil
+}
+
+// uploadVars uploads the extra vars files of the forwarded user variables and
+// of the passwords, if any, to the remote staging directory, where only the
+// user of the connection can read them, and points userVarsFile and
+// passwordVarsFile at them. The returned function removes them from the
+// machine.
+func (p *Provisioner) uploadVars(ui packer.Ui, comm packer.Communicator) (func(), error) {
+	if err := p.writeUserVars(); err != nil {
+		return func() {}, fmt.Errorf("Error preparing the user variables: %s", err)
+	}
+	if err := p.writePasswordVars(); err != nil {
+		return func() {}, fmt.Errorf("Error preparing the passwords: %s", err)
+	}
+
+	var removes []func()
+	remove := func() {
+		for _, remove := range removes {
+			remove()
+		}
+	}
+	for _, file := range []struct {
+		local *string
+		name  string
+	}{
+		{&p.config.userVarsFile, "user_vars.json"},
+		{&p.config.passwordVarsFile, "passwords.json"},
+	} {
+		if *file.local == "" {
+			continue
+		}
+		dst := path.Join(p.config.RemoteStagingDir, file.name)
+		r, err := p.uploadPrivate(ui, comm, *file.local, dst)
+		if err != nil {
+			remove()
+			return func() {}, err
+		}
+		removes = append(removes, r)
+		*file.local = dst
+	}
+	return remove, nil
+}
+
+// uploadPrivate uploads src to dst on the machine, where only the user of the
+// connection can read it. The returned function removes it.
+func (p *Provisioner) uploadPrivate(ui packer.Ui, comm packer.Communicator, src, dst string) (func(), error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The file is created before the upload, so that it is never readable by
+	// others.
+	command := fmt.Sprintf("mkdir -p %s && (umask 077 && : > %s)", shellQuote(p.config.RemoteStagingDir), shellQuote(dst))
+	if err := p.runRemote(ui, comm, command); err != nil {
+		return nil, fmt.Errorf("Error creating %s: %s", dst, err)
+	}
+	remove := func() {
+		if err := p.runRemote(ui, comm, "rm -f "+shellQuote(dst)); err != nil {
+			ui.Error(fmt.Sprintf("Error removing %s: %s", dst, err))
+		}
+	}
+	if err := comm.Upload(dst, f, nil); err != nil {
+		remove()
+		return nil, fmt.Errorf("Error uploading %s: %s", dst, err)
+	}
+	return remove, nil
+}
+
+// remotePlaybook returns the path of playbook relative to the remote staging
+// directory. playbook must be in playbook_dir.
+func (p *Provisioner) remotePlaybook(playbook string) (string, error) {
+	dir, _ := filepath.Abs(p.config.PlaybookDir)
+	abs, _ := filepath.Abs(playbook)
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s must be in playbook_dir %s", playbook, p.config.PlaybookDir)
+	}
+	return path.Join(p.config.RemoteStagingDir, filepath.ToSlash(rel)), nil
+}
+
+// runRemote runs command on the machine and returns an error if it fails.
+func (p *Provisioner) runRemote(ui packer.Ui, comm packer.Communicator, command string) error {
+	cmd := &packer.RemoteCmd{Command: command}
+	if err := cmd.StartWithUi(comm, ui); err != nil {
+		return err
+	}
+	if cmd.ExitStatus != 0 {
+		return fmt.Errorf("non-zero exit status: %d", cmd.ExitStatus)
+	}
+	return nil
+}
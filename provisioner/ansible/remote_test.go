@@ -0,0 +1,58 @@
+package ansible
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProvisioner_ExecuteRemotePasswords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "playbooks")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("PACKER_TEST_BECOME_PASSWORD", "hunter22")
+	defer os.Unsetenv("PACKER_TEST_BECOME_PASSWORD")
+
+	for _, mode := range []string{"", "local"} {
+		var p Provisioner
+		p.config.Mode = mode
+		p.config.Connection = "local"
+		p.config.HostAlias = "default"
+		p.config.Command = "ansible-playbook"
+		p.config.RemoteStagingDir = "/tmp/packer-provisioner-ansible"
+		p.config.PlaybookDir = dir
+		p.config.PlaybookFile = filepath.Join(dir, "site.yml")
+		p.config.BecomePasswordEnv = "PACKER_TEST_BECOME_PASSWORD"
+
+		var commands []string
+		comm := uploadCommunicator{uploads: make(map[string]string), commands: &commands}
+		if err := p.executeRemote(new(ui), comm); err != nil {
+			t.Fatalf("%s: err: %s", mode, err)
+		}
+		p.cleanup()
+
+		passwords := "/tmp/packer-provisioner-ansible/passwords.json"
+		if !strings.Contains(comm.uploads[passwords], `"ansible_become_password":"hunter22"`) {
+			t.Fatalf("%s: expected the passwords to be uploaded, got %v", mode, comm.uploads)
+		}
+		var executed, removed bool
+		for _, command := range commands {
+			switch {
+			case strings.Contains(command, "ansible-playbook"):
+				executed = true
+				if !strings.Contains(command, "-e @"+passwords) || strings.Contains(command, "hunter22") {
+					t.Fatalf("%s: unexpected command %s", mode, command)
+				}
+			case command == "rm -f "+passwords:
+				removed = executed
+			}
+		}
+		if !removed {
+			t.Fatalf("%s: expected the passwords to be removed after the run, got %v", mode, commands)
+		}
+	}
+}
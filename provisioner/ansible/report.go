@@ -0,0 +1,211 @@
+package ansible
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// junitSuites is a JUnit XML report of the tasks of a run.
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Suites   []junitSuite `xml:"testsuite"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Time     float64      `xml:"time,attr"`
+}
+
+// junitSuite is the report of the tasks of a play.
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+// junitCase is the report of a task.
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junit returns a JUnit XML report of the recorded tasks, with a test suite
+// for each play and a test case for each task. A task fails when any of its
+// results failed and was not ignored, and is skipped when it was skipped on
+// every host.
+func (h *eventHandler) junit() *junitSuites {
+	report := &junitSuites{}
+	var suite *junitSuite
+	for _, task := range h.tasks {
+		name := task.Play
+		if task.Playbook != "" {
+			name = task.Playbook + ": " + task.Play
+		}
+		if suite == nil || suite.Name != name {
+			report.Suites = append(report.Suites, junitSuite{Name: name})
+			suite = &report.Suites[len(report.Suites)-1]
+		}
+
+		c := junitCase{
+			Name:      task.Name,
+			Classname: name,
+			Time:      task.Duration().Seconds(),
+		}
+
+		var failures []string
+		skipped := len(task.Results) > 0
+		for _, r := range task.Results {
+			if r.Status != "skipped" {
+				skipped = false
+			}
+			if (r.Status != "failed" && r.Status != "unreachable") || r.IgnoreErrors {
+				continue
+			}
+			s := fmt.Sprintf("%s on %s", r.Status, r.Host)
+			if r.Msg != "" {
+				s = fmt.Sprintf("%s: %s", s, r.Msg)
+			}
+			if r.Stderr != "" {
+				s = fmt.Sprintf("%s\n%s", s, r.Stderr)
+			}
+			failures = append(failures, s)
+		}
+
+		switch {
+		case len(failures) > 0:
+			c.Failure = &junitFailure{
+				Message: strings.SplitN(failures[0], "\n", 2)[0],
+				Text:    strings.Join(failures, "\n"),
+			}
+			suite.Failures++
+			report.Failures++
+		case skipped:
+			c.Skipped = &struct{}{}
+			suite.Skipped++
+		}
+
+		suite.Cases = append(suite.Cases, c)
+		suite.Tests++
+		suite.Time += c.Time
+		report.Tests++
+		report.Time += c.Time
+	}
+	return report
+}
+
+// writeJUnit writes the JUnit XML report of the recorded tasks to w.
+func (h *eventHandler) writeJUnit(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(h.junit()); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeJUnitFile writes the JUnit XML report of the recorded tasks to path.
+func (h *eventHandler) writeJUnitFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := h.writeJUnit(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runSummary is the summary of a run that is written to summary_file.
+type runSummary struct {
+	Duration     float64              `json:"duration"`
+	Totals       hostStats            `json:"totals"`
+	Hosts        map[string]hostStats `json:"hosts"`
+	Tasks        []taskSummary        `json:"tasks"`
+	Failures     []string             `json:"failures"`
+	Warnings     []string             `json:"warnings"`
+	Deprecations []string             `json:"deprecations"`
+	Metadata     *buildMetadata       `json:"metadata,omitempty"`
+}
+
+// taskSummary is the summary of a task and its results.
+type taskSummary struct {
+	Playbook string          `json:"playbook"`
+	Play     string          `json:"play"`
+	Name     string          `json:"name"`
+	Action   string          `json:"action,omitempty"`
+	Start    time.Time       `json:"start"`
+	Duration float64         `json:"duration"`
+	Results  []resultSummary `json:"results"`
+}
+
+type resultSummary struct {
+	Host         string `json:"host"`
+	Status       string `json:"status"`
+	Changed      bool   `json:"changed"`
+	Msg          string `json:"msg,omitempty"`
+	IgnoreErrors bool   `json:"ignore_errors"`
+}
+
+// runSummary returns the summary of a run that took d.
+func (h *eventHandler) runSummary(d time.Duration) *runSummary {
+	s := &runSummary{
+		Duration:     d.Seconds(),
+		Totals:       h.totals(),
+		Hosts:        h.stats,
+		Tasks:        []taskSummary{},
+		Failures:     h.failures(),
+		Warnings:     h.warnings,
+		Deprecations: h.deprecations,
+	}
+	for _, task := range h.tasks {
+		t := taskSummary{
+			Playbook: task.Playbook,
+			Play:     task.Play,
+			Name:     task.Name,
+			Action:   task.Action,
+			Start:    task.Start,
+			Duration: task.Duration().Seconds(),
+			Results:  []resultSummary{},
+		}
+		for _, r := range task.Results {
+			t.Results = append(t.Results, resultSummary{
+				Host:         r.Host,
+				Status:       r.Status,
+				Changed:      r.Changed,
+				Msg:          r.Msg,
+				IgnoreErrors: r.IgnoreErrors,
+			})
+		}
+		s.Tasks = append(s.Tasks, t)
+	}
+	return s
+}
+
+// writeSummaryFile writes s to path as JSON.
+func writeSummaryFile(path string, s *runSummary) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
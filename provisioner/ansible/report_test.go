@@ -0,0 +1,81 @@
+package ansible
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventHandler_JUnit(t *testing.T) {
+	h := newEventHandler(newUi(new(ui)))
+
+	now := time.Now()
+	for i, line := range []string{
+		"PLAY [all] *********************************************************************",
+		"TASK [setup] *******************************************************************",
+		"ok: [default]",
+		"TASK [skipped] *****************************************************************",
+		"skipping: [default]",
+		"TASK [install packages] ********************************************************",
+		`fatal: [default]: FAILED! => {"changed": false, "failed": true, "msg": "No package matching 'foo' is available"}`,
+	} {
+		h.parseText(line, now.Add(time.Duration(i)*time.Second))
+	}
+
+	report := h.junit()
+	if report.Tests != 3 || report.Failures != 1 || len(report.Suites) != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	suite := report.Suites[0]
+	if suite.Name != "all" || suite.Skipped != 1 {
+		t.Fatalf("unexpected suite: %+v", suite)
+	}
+	if c := suite.Cases[0]; c.Failure != nil || c.Skipped != nil || c.Time != 1 {
+		t.Fatalf("unexpected test case: %+v", c)
+	}
+	if c := suite.Cases[1]; c.Skipped == nil {
+		t.Fatalf("expected %q to be skipped", c.Name)
+	}
+	c := suite.Cases[2]
+	if c.Failure == nil {
+		t.Fatalf("expected %q to fail", c.Name)
+	}
+	if expected := "failed on default: No package matching 'foo' is available"; c.Failure.Message != expected {
+		t.Fatalf("expected %s, got %s", expected, c.Failure.Message)
+	}
+
+	var buf bytes.Buffer
+	if err := h.writeJUnit(&buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(buf.String(), `<testcase name="install packages" classname="all"`) {
+		t.Fatalf("unexpected report:\n%s", buf.String())
+	}
+}
+
+func TestEventHandler_RunSummary(t *testing.T) {
+	h := newEventHandler(newUi(new(ui)))
+
+	now := time.Now()
+	for i, line := range []string{
+		"PLAY [all] *********************************************************************",
+		"TASK [setup] *******************************************************************",
+		"changed: [default]",
+		"PLAY RECAP *********************************************************************",
+		"default                    : ok=1    changed=1    unreachable=0    failed=0   ",
+	} {
+		h.parseText(line, now.Add(time.Duration(i)*time.Second))
+	}
+
+	s := h.runSummary(3 * time.Second)
+	if s.Duration != 3 || s.Totals.Changed != 1 || s.Hosts["default"].Ok != 1 {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+	if len(s.Tasks) != 1 || s.Tasks[0].Name != "setup" || s.Tasks[0].Duration != 1 {
+		t.Fatalf("unexpected tasks: %+v", s.Tasks)
+	}
+	if r := s.Tasks[0].Results; len(r) != 1 || r[0].Status != "ok" || !r[0].Changed {
+		t.Fatalf("unexpected results: %+v", r)
+	}
+}
@@ -0,0 +1,255 @@
+package ansible
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// installedRolePattern matches a role in the output of ansible-galaxy list,
+// e.g. "- geerlingguy.java, 1.9.0".
+var installedRolePattern = regexp.MustCompile(`^- ([^,\s]+), (.+)$`)
+
+// roleRequirement is a role in a requirements file. Keys holds all of its
+// keys, such as scm, as they are written, with src set.
+type roleRequirement struct {
+	Src     string
+	Name    string
+	Version string
+	Keys    map[string]string
+}
+
+// parseRoleRequirements parses the roles of a requirements file. Only the
+// block style in which requirements files are usually written is supported: a
+// list of roles, optionally under a roles key, each either a mapping of keys
+// to plain or quoted values or a string of the form src[,version[,name]].
+// Anything else, such as the flow style, include entries, nested values, or
+// other keys at the top level than roles and collections, is an error rather
+// than misread.
+func parseRoleRequirements(r io.Reader) ([]roleRequirement, error) {
+	var (
+		reqs    []roleRequirement
+		entry   map[string]string
+		indent  int // of the keys of entry
+		section = "roles"
+		n       int
+	)
+	add := func() {
+		if entry == nil {
+			return
+		}
+		if entry["src"] == "" {
+			entry["src"] = entry["name"]
+		}
+		name := entry["name"]
+		if name == "" {
+			name = roleName(entry["src"])
+		}
+		if name != "" {
+			reqs = append(reqs, roleRequirement{Src: entry["src"], Name: name, Version: entry["version"], Keys: entry})
+		}
+		entry = nil
+	}
+	unsupported := func(what string) error {
+		return fmt.Errorf("line %d: %s is not supported", n, what)
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		n++
+		line := stripComment(scanner.Text())
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			return nil, unsupported("indenting with tabs")
+		}
+		depth := len(line) - len(strings.TrimLeft(line, " "))
+
+		if depth == 0 && trimmed[0] != '-' {
+			add()
+			key, value, ok := splitYAMLKey(trimmed)
+			if !ok || value != "" || (key != "roles" && key != "collections") {
+				return nil, unsupported(fmt.Sprintf("the top-level %q", trimmed))
+			}
+			section = key
+			continue
+		}
+		if section != "roles" {
+			continue
+		}
+
+		if trimmed[0] == '-' {
+			add()
+			rest := strings.TrimSpace(trimmed[1:])
+			if rest == "" || rest == trimmed[1:] {
+				return nil, unsupported("a list item that is not a role")
+			}
+			if strings.ContainsAny(rest[:1], "[{|>&*!") {
+				return nil, unsupported(fmt.Sprintf("the role %q", rest))
+			}
+			entry = make(map[string]string)
+			indent = depth + len(trimmed) - len(rest)
+			if _, _, ok := splitYAMLKey(rest); !ok {
+				fields := strings.Split(unquote(rest), ",")
+				keys := []string{"src", "version", "name"}
+				for i := 0; i < len(fields) && i < len(keys); i++ {
+					if field := strings.TrimSpace(fields[i]); field != "" {
+						entry[keys[i]] = field
+					}
+				}
+				// A string role has no keys that could follow.
+				indent = -1
+				continue
+			}
+			trimmed = rest
+		} else if entry == nil || depth != indent {
+			return nil, unsupported("nesting")
+		}
+
+		key, value, ok := splitYAMLKey(trimmed)
+		switch {
+		case !ok:
+			return nil, unsupported(fmt.Sprintf("%q", trimmed))
+		case key == "include":
+			return nil, unsupported("include")
+		case value == "":
+			return nil, unsupported(fmt.Sprintf("the nested value of %s", key))
+		case strings.ContainsAny(value[:1], "[{|>&*!"):
+			return nil, unsupported(fmt.Sprintf("the value %q of %s", value, key))
+		}
+		entry[key] = unquote(value)
+	}
+	add()
+	return reqs, scanner.Err()
+}
+
+// splitYAMLKey splits the line of a mapping into its key and value, and
+// reports whether it is one.
+func splitYAMLKey(line string) (key, value string, ok bool) {
+	if strings.HasSuffix(line, ":") {
+		return strings.TrimSpace(line[:len(line)-1]), "", true
+	}
+	if i := strings.Index(line, ": "); i >= 0 {
+		return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+2:]), true
+	}
+	return "", "", false
+}
+
+// roleName returns the name under which ansible-galaxy installs the role from
+// src: the name of a Galaxy role, or the last part of the URL of a role from
+// source control or an archive.
+func roleName(src string) string {
+	if !strings.Contains(src, "://") && !strings.Contains(src, "@") {
+		return src
+	}
+	name := src[strings.LastIndexAny(src, "/:")+1:]
+	for _, ext := range []string{".git", ".tar.gz", ".tgz", ".tar"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// stripComment removes a YAML comment from line.
+func stripComment(line string) string {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return ""
+	}
+	if i := strings.Index(line, " #"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// parseInstalledRoles parses the versions of the roles listed by
+// ansible-galaxy list.
+func parseInstalledRoles(out string) map[string]string {
+	roles := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if m := installedRolePattern.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			roles[m[1]] = strings.TrimSpace(m[2])
+		}
+	}
+	return roles
+}
+
+// verifyRoles describes each required role that is not installed at the
+// required version.
+func verifyRoles(reqs []roleRequirement, installed map[string]string) []string {
+	var mismatches []string
+	for _, req := range reqs {
+		version, ok := installed[req.Name]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, fmt.Sprintf("%s is not installed", req.Name))
+		case req.Version != "" && version != req.Version:
+			mismatches = append(mismatches, fmt.Sprintf("%s is %s, expected %s", req.Name, version, req.Version))
+		}
+	}
+	return mismatches
+}
+
+// verifyGalaxy verifies that the installed roles match the versions in
+// galaxy_file, and that the installed collections match the checksums of the
+// Galaxy server.
+func (p *Provisioner) verifyGalaxy(ui packer.Ui) error {
+	if p.config.GalaxyFile != "" {
+		f, err := os.Open(p.config.GalaxyFile)
+		if err != nil {
+			return err
+		}
+		reqs, err := parseRoleRequirements(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("Error reading galaxy_file: %s", err)
+		}
+
+		args := []string{"list"}
+		if path := p.galaxyRolesPath(); path != "" {
+			path, _ = filepath.Abs(path)
+			args = append(args, "-p", path)
+		}
+		cmd := p.galaxyCommand(args)
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("Error listing installed roles: %s", err)
+		}
+
+		ui.Say(fmt.Sprintf("Verifying %d roles", len(reqs)))
+		if mismatches := verifyRoles(reqs, parseInstalledRoles(stdout.String())); len(mismatches) > 0 {
+			return fmt.Errorf("Error verifying roles: %s", strings.Join(mismatches, "; "))
+		}
+	}
+
+	if p.config.GalaxyCollectionsFile != "" {
+		file, _ := filepath.Abs(p.config.GalaxyCollectionsFile)
+		args := []string{"collection", "verify", "-r", file}
+		if path := p.galaxyCollectionsPath(); path != "" {
+			path, _ = filepath.Abs(path)
+			args = append(args, "-p", path)
+		}
+		cmd := p.galaxyCommand(args)
+		ui.Say(fmt.Sprintf("Verifying collections: %s", p.redactSecrets(strings.Join(cmd.Args, " "))))
+		if err := runCommand(ui, cmd, ui.Message, ui.Message); err != nil {
+			return fmt.Errorf("Error verifying collections: %s", err)
+		}
+	}
+
+	return nil
+}
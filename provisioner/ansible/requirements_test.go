@@ -0,0 +1,96 @@
+package ansible
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRoleRequirements(t *testing.T) {
+	for _, file := range []string{
+		`---
+# from galaxy
+- src: geerlingguy.java
+  version: 1.9.0
+
+- src: https://github.com/bennojoy/nginx.git
+  scm: git
+  version: "v1.4" # pinned
+- name: custom
+  src: git+git@example.com:roles/custom-role.git
+- geerlingguy.git,2.0.1
+`,
+		`roles:
+  - src: geerlingguy.java
+    version: 1.9.0
+  - src: https://github.com/bennojoy/nginx.git
+    scm: git
+    version: 'v1.4'
+  - name: custom
+    src: git+git@example.com:roles/custom-role.git
+  - geerlingguy.git,2.0.1
+
+collections:
+  - name: community.general
+    version: 1.0.0
+`,
+	} {
+		reqs, err := parseRoleRequirements(strings.NewReader(file))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		expected := []roleRequirement{
+			{"geerlingguy.java", "geerlingguy.java", "1.9.0",
+				map[string]string{"src": "geerlingguy.java", "version": "1.9.0"}},
+			{"https://github.com/bennojoy/nginx.git", "nginx", "v1.4",
+				map[string]string{"src": "https://github.com/bennojoy/nginx.git", "scm": "git", "version": "v1.4"}},
+			{"git+git@example.com:roles/custom-role.git", "custom", "",
+				map[string]string{"name": "custom", "src": "git+git@example.com:roles/custom-role.git"}},
+			{"geerlingguy.git", "geerlingguy.git", "2.0.1",
+				map[string]string{"src": "geerlingguy.git", "version": "2.0.1"}},
+		}
+		if !reflect.DeepEqual(reqs, expected) {
+			t.Fatalf("expected %v, got %v", expected, reqs)
+		}
+	}
+}
+
+func TestParseRoleRequirements_Unsupported(t *testing.T) {
+	for _, file := range []string{
+		"- {src: geerlingguy.java, version: 1.9.0}\n",
+		"roles: [geerlingguy.java]\n",
+		"- include: other.yml\n",
+		"- src: geerlingguy.java\n  version:\n    1.9.0\n",
+		"- src: geerlingguy.java\n    version: 1.9.0\n",
+		"- geerlingguy.java\n  version: 1.9.0\n",
+		"- src: &java geerlingguy.java\n",
+		"dependencies:\n  - geerlingguy.java\n",
+		"-\n  - geerlingguy.java\n",
+	} {
+		if reqs, err := parseRoleRequirements(strings.NewReader(file)); err == nil {
+			t.Fatalf("should error for %q, got %v", file, reqs)
+		}
+	}
+}
+
+func TestVerifyRoles(t *testing.T) {
+	installed := parseInstalledRoles(`# /etc/ansible/roles
+- geerlingguy.java, 1.8.0
+- nginx, v1.4
+- custom, (unknown version)
+`)
+
+	mismatches := verifyRoles([]roleRequirement{
+		{Name: "geerlingguy.java", Version: "1.9.0"},
+		{Name: "nginx", Version: "v1.4"},
+		{Name: "custom"},
+		{Name: "geerlingguy.git", Version: "2.0.1"},
+	}, installed)
+	expected := []string{
+		"geerlingguy.java is 1.8.0, expected 1.9.0",
+		"geerlingguy.git is not installed",
+	}
+	if !reflect.DeepEqual(mismatches, expected) {
+		t.Fatalf("expected %v, got %v", expected, mismatches)
+	}
+}
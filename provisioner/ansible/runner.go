@@ -0,0 +1,279 @@
+package ansible
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// prepareRunner checks the ansible-runner options.
+func (p *Provisioner) prepareRunner() []error {
+	if !p.config.UseRunner {
+		return nil
+	}
+	var errs []error
+	if p.config.RunnerCommand == "" {
+		p.config.RunnerCommand = "ansible-runner"
+	}
+	if p.config.UseNavigator {
+		errs = append(errs, errors.New("use_runner cannot be used with use_navigator"))
+	}
+	if p.config.StructuredOutput {
+		errs = append(errs, errors.New("use_runner cannot be used with structured_output"))
+	}
+	return errs
+}
+
+// writeRunnerDir writes the private data directory of ansible-runner: the
+// inventory, linked to the inventory of the run, env/envvars with the
+// environment overrides, and env/extravars with the forwarded user
+// variables. The directory is temporary, so that the builds of a template
+// that run at the same time do not overwrite each other's files; the
+// contents of runner_private_data_dir, such as the project and the
+// artifacts, are linked into it.
+func (p *Provisioner) writeRunnerDir() error {
+	dir, err := p.tempDir("runner")
+	if err != nil {
+		return err
+	}
+	p.track(dir)
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if p.config.RunnerPrivateDataDir != "" {
+		if err := linkRunnerDir(dir, p.config.RunnerPrivateDataDir); err != nil {
+			return err
+		}
+	}
+	for _, sub := range []string{"env", "inventory"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+
+	inventory, err := filepath.Abs(p.config.inventoryFile)
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(inventory, filepath.Join(dir, "inventory", "hosts")); err != nil {
+		return err
+	}
+
+	envvars := make(map[string]string)
+	for _, v := range p.env() {
+		kv := strings.SplitN(v, "=", 2)
+		envvars[kv[0]] = kv[1]
+	}
+	extravars := p.userVariables()
+	if extravars == nil {
+		extravars = make(map[string]string)
+	}
+	for name, vars := range map[string]map[string]string{"envvars": envvars, "extravars": extravars} {
+		b, _ := json.MarshalIndent(vars, "", "  ")
+		if err := ioutil.WriteFile(filepath.Join(dir, "env", name), append(b, '\n'), 0600); err != nil {
+			return err
+		}
+	}
+
+	p.config.runnerDir = dir
+	return nil
+}
+
+// linkRunnerDir links the contents of the private data directory src into
+// dir, except for the inventory and the files of env that the provisioner
+// writes. The artifacts directory is created in src, so that the artifacts
+// are kept there.
+func linkRunnerDir(dir, src string) error {
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(src, "artifacts"), 0755); err != nil {
+		return err
+	}
+	link := func(sub string, skip ...string) error {
+		infos, err := ioutil.ReadDir(filepath.Join(src, sub))
+		if err != nil {
+			return err
+		}
+	entries:
+		for _, fi := range infos {
+			for _, name := range skip {
+				if fi.Name() == name {
+					continue entries
+				}
+			}
+			if err := os.Symlink(filepath.Join(src, sub, fi.Name()), filepath.Join(dir, sub, fi.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := link("", "env", "inventory"); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(src, "env")); err != nil {
+		return nil
+	}
+	if err := os.Mkdir(filepath.Join(dir, "env"), 0755); err != nil {
+		return err
+	}
+	return link("env", "envvars", "extravars")
+}
+
+// runnerArgs returns the arguments of ansible-runner to run playbook, with
+// its events written to stdout as JSON. The options of ansible-playbook
+// that are not in the private data directory are passed with --cmdline.
+func (p *Provisioner) runnerArgs(playbook string) []string {
+	args := []string{"run", p.config.runnerDir, "-p", playbook, "--ident", p.runnerIdent(playbook), "-j"}
+	if options := p.playbookOptions(false); len(options) > 0 {
+		quoted := make([]string, len(options))
+		for i, option := range options {
+			quoted[i] = shellQuote(option)
+		}
+		args = append(args, "--cmdline", strings.Join(quoted, " "))
+	}
+	return args
+}
+
+// runnerIdent returns the identifier of the run of playbook, under which
+// ansible-runner stores its artifacts. It starts with the build name, so that
+// the builds that share runner_private_data_dir keep their artifacts apart.
+func (p *Provisioner) runnerIdent(playbook string) string {
+	prefix := ""
+	if id := p.buildID(); id != "" {
+		prefix = id + "-"
+	}
+	playbook, _ = filepath.Abs(playbook)
+	for i, name := range p.playbooks() {
+		if abs, _ := filepath.Abs(name); abs == playbook {
+			return fmt.Sprintf("%splaybook-%d", prefix, i+1)
+		}
+	}
+	return prefix + "playbook"
+}
+
+// runnerError returns err, the result of running playbook with
+// ansible-runner, with the status that ansible-runner recorded in its
+// artifacts. A run that is not successful is an error even if ansible-runner
+// exited successfully.
+func (p *Provisioner) runnerError(playbook string, err error) error {
+	b, rerr := ioutil.ReadFile(filepath.Join(p.config.runnerDir, "artifacts", p.runnerIdent(playbook), "status"))
+	if rerr != nil {
+		return err
+	}
+	status := strings.TrimSpace(string(b))
+	switch {
+	case err != nil:
+		return fmt.Errorf("%s, ansible-runner status %s", err, status)
+	case status != "successful":
+		return fmt.Errorf("ansible-runner status %s", status)
+	}
+	return nil
+}
+
+// runnerEvent is a job event of ansible-runner.
+type runnerEvent struct {
+	Event     string `json:"event"`
+	Created   string `json:"created"`
+	Stdout    string `json:"stdout"`
+	EventData struct {
+		Playbook     string `json:"playbook"`
+		Play         string `json:"play"`
+		Task         string `json:"task"`
+		TaskAction   string `json:"task_action"`
+		Host         string `json:"host"`
+		IgnoreErrors bool   `json:"ignore_errors"`
+		Res          struct {
+			Changed bool        `json:"changed"`
+			Msg     interface{} `json:"msg"`
+			Stderr  interface{} `json:"stderr"`
+		} `json:"res"`
+
+		// The counts of the recap, by host.
+		Ok       map[string]int `json:"ok"`
+		Changed  map[string]int `json:"changed"`
+		Failures map[string]int `json:"failures"`
+		Dark     map[string]int `json:"dark"`
+		Skipped  map[string]int `json:"skipped"`
+	} `json:"event_data"`
+}
+
+// runnerResults are the statuses of the results of the job events of
+// ansible-runner.
+var runnerResults = map[string]string{
+	"runner_on_ok":          "ok",
+	"runner_on_failed":      "failed",
+	"runner_on_skipped":     "skipped",
+	"runner_on_unreachable": "unreachable",
+}
+
+// RunnerLine handles a line of the stdout of ansible-runner, a job event. The
+// events are handled like those of the bundled callback plugin; the output of
+// the others, e.g. warnings, is relayed to the ui.
+func (h *eventHandler) RunnerLine(line string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var re runnerEvent
+	if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &re) != nil || re.Event == "" {
+		h.relay(line)
+		return
+	}
+
+	e := event{Time: runnerTime(re.Created)}
+	data := re.EventData
+	switch re.Event {
+	case "playbook_on_start":
+		e.Event, e.Playbook = "playbook_start", data.Playbook
+	case "playbook_on_play_start":
+		e.Event, e.Play = "play_start", data.Play
+	case "playbook_on_task_start", "playbook_on_handler_task_start":
+		e.Event, e.Task, e.Action = "task_start", data.Task, data.TaskAction
+		e.Handler = re.Event == "playbook_on_handler_task_start"
+	case "playbook_on_stats":
+		e.Event, e.Stats = "stats", make(map[string]hostStats)
+		for _, counts := range []map[string]int{data.Ok, data.Changed, data.Failures, data.Dark, data.Skipped} {
+			for host := range counts {
+				e.Stats[host] = hostStats{
+					Ok:          data.Ok[host],
+					Changed:     data.Changed[host],
+					Failures:    data.Failures[host],
+					Unreachable: data.Dark[host],
+					Skipped:     data.Skipped[host],
+				}
+			}
+		}
+	default:
+		status, ok := runnerResults[re.Event]
+		if !ok {
+			if re.Stdout != "" {
+				for _, line := range strings.Split(re.Stdout, "\n") {
+					h.relay(line)
+				}
+			}
+			return
+		}
+		e.Event, e.Status = "task_result", status
+		e.Host, e.Task, e.Action = data.Host, data.Task, data.TaskAction
+		e.Changed, e.Msg, e.Stderr = data.Res.Changed, text(data.Res.Msg), text(data.Res.Stderr)
+		e.IgnoreErrors = data.IgnoreErrors
+	}
+	h.handle(&e)
+}
+
+// runnerTime returns the time, in seconds since the epoch, at which a job
+// event was created, or 0 when it cannot be parsed.
+func runnerTime(created string) float64 {
+	t, err := time.Parse("2006-01-02T15:04:05.999999", created)
+	if err != nil {
+		return 0
+	}
+	return float64(t.UnixNano()) / float64(time.Second)
+}
@@ -0,0 +1,143 @@
+package ansible
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProvisioner_Runner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"project", "env"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "env", "ssh_key"), nil, 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var p Provisioner
+	p.config.UseRunner = true
+	p.config.PlaybookFile = "/srv/playbook.yml"
+	p.config.RunnerPrivateDataDir = dir
+	p.config.inventoryFile = filepath.Join(dir, "inventory.ini")
+	p.config.ForwardUserVariables = true
+	p.config.PackerUserVars = map[string]string{"version": "1.0"}
+	p.config.AnsibleEnvVars = []string{"ANSIBLE_FORKS=1"}
+	p.config.ExtraArguments = []string{"--tags", "base and web"}
+	if errs := p.prepareRunner(); len(errs) > 0 {
+		t.Fatalf("err: %v", errs)
+	}
+	if err := p.writeRunnerDir(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer p.cleanup()
+
+	runnerDir := p.config.runnerDir
+	if runnerDir == dir {
+		t.Fatal("expected a private data directory for the run")
+	}
+	if target, err := os.Readlink(filepath.Join(runnerDir, "inventory", "hosts")); err != nil || target != p.config.inventoryFile {
+		t.Fatalf("expected the inventory to link to %s, got %s (%v)", p.config.inventoryFile, target, err)
+	}
+	for _, name := range []string{"project", "artifacts", filepath.Join("env", "ssh_key")} {
+		if target, err := os.Readlink(filepath.Join(runnerDir, name)); err != nil || target != filepath.Join(dir, name) {
+			t.Fatalf("expected %s to link to runner_private_data_dir, got %s (%v)", name, target, err)
+		}
+	}
+	for name, expected := range map[string]string{
+		"envvars":   "{\n  \"ANSIBLE_FORKS\": \"1\",\n  \"ANSIBLE_RETRY_FILES_ENABLED\": \"False\"\n}\n",
+		"extravars": "{\n  \"version\": \"1.0\"\n}\n",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(runnerDir, "env", name))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if string(b) != expected {
+			t.Fatalf("expected %s to be %q, got %q", name, expected, b)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "env", name)); err == nil {
+			t.Fatalf("expected no %s in runner_private_data_dir", name)
+		}
+	}
+
+	cmd := p.ansibleCommand(p.config.PlaybookFile)
+	expected := []string{"ansible-runner", "run", runnerDir, "-p", "/srv/playbook.yml", "--ident", "playbook-1", "-j",
+		"--cmdline", "--tags 'base and web'"}
+	if !reflect.DeepEqual(cmd.Args, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmd.Args)
+	}
+	p.config.PackerBuildName = "amazon-ebs"
+	if ident := p.runnerIdent(p.config.PlaybookFile); ident != "amazon-ebs-playbook-1" {
+		t.Fatalf("expected the ident to start with the build name, got %s", ident)
+	}
+	p.config.PackerBuildName = ""
+
+	if err := p.runnerError(p.config.PlaybookFile, nil); err != nil {
+		t.Fatalf("expected no error without a status, got %s", err)
+	}
+	artifacts := filepath.Join(dir, "artifacts", "playbook-1")
+	if err := os.MkdirAll(artifacts, 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(artifacts, "status"), []byte("timeout\n"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := p.runnerError(p.config.PlaybookFile, nil); err == nil || err.Error() != "ansible-runner status timeout" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.runnerError(p.config.PlaybookFile, errors.New("exit status 2")); err == nil || err.Error() != "exit status 2, ansible-runner status timeout" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestEventHandler_RunnerLine(t *testing.T) {
+	h := newEventHandler(newUi(new(ui)))
+
+	for _, line := range []string{
+		`{"event": "playbook_on_start", "created": "2016-03-01T10:00:00.000000", "event_data": {"playbook": "playbook.yml"}}`,
+		`{"event": "playbook_on_play_start", "created": "2016-03-01T10:00:00.000000", "event_data": {"play": "all"}}`,
+		`{"event": "playbook_on_task_start", "created": "2016-03-01T10:00:00.000000", "event_data": {"task": "install packages", "task_action": "apt"}}`,
+		`{"event": "verbose", "stdout": "[WARNING]: no python"}`,
+		`{"event": "runner_on_ok", "created": "2016-03-01T10:00:02.500000", "event_data": {"task": "install packages", "host": "default", "res": {"changed": true}}}`,
+		`{"event": "playbook_on_task_start", "created": "2016-03-01T10:00:02.500000", "event_data": {"task": "start service", "task_action": "service"}}`,
+		`{"event": "runner_on_failed", "created": "2016-03-01T10:00:03.000000", "event_data": {"task": "start service", "host": "default", "res": {"msg": "no such service"}}}`,
+		`{"event": "playbook_on_stats", "created": "2016-03-01T10:00:03.000000", "event_data": {"ok": {"default": 1}, "changed": {"default": 1}, "failures": {"default": 1}}}`,
+	} {
+		h.RunnerLine(line)
+	}
+
+	if len(h.tasks) != 2 {
+		t.Fatalf("expected 2 tasks, got %d", len(h.tasks))
+	}
+	task := h.tasks[0]
+	if task.Name != "install packages" || task.Play != "all" || task.Playbook != "playbook.yml" || task.Action != "apt" {
+		t.Fatalf("unexpected task: %+v", task)
+	}
+	if d := task.Duration(); d != 2500*time.Millisecond {
+		t.Fatalf("expected a duration of 2.5s, got %s", d)
+	}
+	if len(task.Results) != 1 || !task.Results[0].Changed {
+		t.Fatalf("unexpected results: %+v", task.Results)
+	}
+	task = h.tasks[1]
+	if len(task.Results) != 1 || task.Results[0].Status != "failed" || task.Results[0].Msg != "no such service" {
+		t.Fatalf("unexpected results: %+v", task.Results)
+	}
+	if s := h.stats["default"]; s.Ok != 1 || s.Changed != 1 || s.Failures != 1 {
+		t.Fatalf("unexpected stats: %+v", h.stats)
+	}
+	if len(h.warnings) != 1 {
+		t.Fatalf("expected the warning to be recorded, got %v", h.warnings)
+	}
+}
@@ -0,0 +1,135 @@
+package ansible
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// shareState is what a block that sets share_proxy records for the blocks of
+// the build that follow it: the port of its SSH proxy and its inventory, which
+// they reuse. Each block still runs its own SSH proxy.
+type shareState struct {
+	LocalPort     string `json:"local_port"`
+	InventoryFile string `json:"inventory_file"`
+}
+
+// prepareShare checks the share_proxy option.
+func (p *Provisioner) prepareShare() []error {
+	if !p.config.ShareProxy {
+		return nil
+	}
+	if p.config.InventoryFile != "" {
+		return []error{errors.New("share_proxy cannot be used with inventory_file")}
+	}
+	return nil
+}
+
+// sharesProxy reports whether the port of the SSH proxy and the inventory are
+// shared with the later blocks of the build. share_proxy has no effect unless
+// ansible connects through the SSH proxy.
+func (p *Provisioner) sharesProxy() bool {
+	return p.config.ShareProxy && p.useProxy()
+}
+
+// shareDir returns the directory in which the blocks of the builds of the
+// Packer process record the port of the SSH proxy and keep the inventory that
+// the later blocks reuse. Packer runs each block in its own plugin process, so
+// the directory is named after the Packer process, which is the parent of all
+// of them.
+func (p *Provisioner) shareDir() string {
+	return filepath.Join(p.shareRoot(), fmt.Sprintf("%s%d", sharePrefix, os.Getppid()))
+}
+
+// sharePrefix starts the names of the share directories.
+const sharePrefix = "packer-provisioner-ansible-share-"
+
+func (p *Provisioner) shareRoot() string {
+	if p.config.StagingDir != "" {
+		return p.config.StagingDir
+	}
+	return os.TempDir()
+}
+
+// shareFile returns the file in which the blocks of the build record the port
+// of the SSH proxy and the inventory.
+func (p *Provisioner) shareFile() string {
+	return filepath.Join(p.shareDir(), p.buildID()+".json")
+}
+
+// sharedInventoryDir creates the directory of an inventory that the later
+// blocks of the build reuse, so that it is removed along with the share
+// directory.
+func (p *Provisioner) sharedInventoryDir() (string, error) {
+	dir := p.shareDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return ioutil.TempDir(dir, p.buildID()+"-inventory")
+}
+
+// loadShare returns what an earlier block of the build recorded, or nil when
+// there is none or its inventory is gone. Unless local_port is set, the SSH
+// proxy is started on the port of the earlier block's.
+func (p *Provisioner) loadShare() *shareState {
+	b, err := ioutil.ReadFile(p.shareFile())
+	if err != nil {
+		return nil
+	}
+	var s shareState
+	if err := json.Unmarshal(b, &s); err != nil || s.LocalPort == "" {
+		return nil
+	}
+	if _, err := os.Stat(s.InventoryFile); err != nil {
+		return nil
+	}
+	if p.config.LocalPort == "0" {
+		p.config.LocalPort = s.LocalPort
+	}
+	return &s
+}
+
+// saveShare records the port of the SSH proxy and the inventory for the blocks
+// of the build that follow, and removes the share directories of the Packer
+// processes that have exited, whose builds have ended.
+func (p *Provisioner) saveShare() error {
+	removeEndedShares(p.shareRoot())
+	if err := os.MkdirAll(p.shareDir(), 0700); err != nil {
+		return err
+	}
+	b, _ := json.Marshal(shareState{LocalPort: p.config.LocalPort, InventoryFile: p.config.inventoryFile})
+	return ioutil.WriteFile(p.shareFile(), b, 0600)
+}
+
+// removeEndedShares removes the share directories in root of the Packer
+// processes that are no longer running.
+func removeEndedShares(root string) {
+	dirs, _ := filepath.Glob(filepath.Join(root, sharePrefix+"*"))
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), sharePrefix))
+		if err != nil || pid == os.Getppid() || processRunning(pid) {
+			continue
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// processRunning reports whether the process pid is running.
+func processRunning(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// FindProcess opens the process, which fails once it has exited.
+		return true
+	}
+	return proc.Signal(syscall.Signal(0)) == nil
+}
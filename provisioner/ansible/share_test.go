@@ -0,0 +1,158 @@
+package ansible
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProvisioner_Share(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var first Provisioner
+	first.config.StagingDir = dir
+	first.config.PackerBuildName = "amazon-ebs/web"
+	first.config.LocalPort = "37121"
+	first.config.inventoryFile = dir
+	if err := first.saveShare(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if name := first.shareFile(); filepath.Base(name) != "amazon-ebs_web.json" || !strings.HasPrefix(filepath.Base(filepath.Dir(name)), sharePrefix) {
+		t.Fatalf("unexpected share file %s", name)
+	}
+
+	var next Provisioner
+	next.config.StagingDir = dir
+	next.config.PackerBuildName = "amazon-ebs/web"
+	next.config.LocalPort = "0"
+	share := next.loadShare()
+	if share == nil {
+		t.Fatal("expected the share of the first block")
+	}
+	if next.config.LocalPort != "37121" || share.InventoryFile != dir {
+		t.Fatalf("expected port 37121 and inventory %s, got %s and %s", dir, next.config.LocalPort, share.InventoryFile)
+	}
+
+	var other Provisioner
+	other.config.StagingDir = dir
+	other.config.PackerBuildName = "docker"
+	if other.loadShare() != nil {
+		t.Fatal("expected no share for another build")
+	}
+
+	first.config.inventoryFile = filepath.Join(dir, "missing")
+	if err := first.saveShare(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if next.loadShare() != nil {
+		t.Fatal("expected no share when its inventory is gone")
+	}
+}
+
+func TestProvisioner_ShareInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.StagingDir = dir
+	p.config.ShareProxy = true
+	if err := p.generateInventory(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	kept := p.keptInventory
+	if !strings.HasPrefix(kept, p.shareDir()) {
+		t.Fatalf("expected the inventory to be kept in the share directory, got %q", kept)
+	}
+	p.cleanup()
+	u := new(messageUi)
+	p.reportKeptInventory(u)
+	if len(u.messages) != 1 || u.messages[0] != "Keeping inventory "+kept {
+		t.Fatalf("expected the kept inventory to be reported, got %v", u.messages)
+	}
+
+	os.RemoveAll(kept)
+	u = new(messageUi)
+	p.reportKeptInventory(u)
+	if len(u.messages) != 0 {
+		t.Fatalf("expected a removed inventory not to be reported, got %v", u.messages)
+	}
+
+	useProxy := false
+	p = Provisioner{}
+	p.config.HostAlias = "default"
+	p.config.StagingDir = dir
+	p.config.ShareProxy = true
+	p.config.UseProxy = &useProxy
+	if err := p.generateInventory(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(p.keptInventory) != 0 {
+		t.Fatalf("expected the inventory not to be shared without the SSH proxy, got %s", p.keptInventory)
+	}
+	inventory := p.config.inventoryFile
+	p.cleanup()
+	if _, err := os.Stat(inventory); !os.IsNotExist(err) {
+		t.Fatalf("expected the inventory to be removed: %v", err)
+	}
+}
+
+func TestProvisioner_ShareEnded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The share directory of a Packer process that has exited is removed.
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	ended := filepath.Join(dir, fmt.Sprintf("%s%d", sharePrefix, cmd.Process.Pid))
+	if err := os.Mkdir(ended, 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var p Provisioner
+	p.config.StagingDir = dir
+	p.config.ShareProxy = true
+	p.config.LocalPort = "37121"
+	inventory, err := p.sharedInventoryDir()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if filepath.Dir(inventory) != p.shareDir() {
+		t.Fatalf("expected the inventory in %s, got %s", p.shareDir(), inventory)
+	}
+	p.config.inventoryFile = inventory
+	if err := p.saveShare(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := os.Stat(ended); !os.IsNotExist(err) {
+		t.Fatalf("expected the share directory of an ended build to be removed: %v", err)
+	}
+	if _, err := os.Stat(p.shareFile()); err != nil {
+		t.Fatalf("expected the share of the running build to be kept: %s", err)
+	}
+}
+
+func TestProvisionerPrepare_ShareProxy(t *testing.T) {
+	var p Provisioner
+	p.config.ShareProxy = true
+	p.config.InventoryFile = "hosts"
+	if errs := p.prepareShare(); len(errs) == 0 {
+		t.Fatal("should error with inventory_file")
+	}
+}
@@ -0,0 +1,51 @@
+package ansible
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// stagingDir returns the directory in staging_dir in which the files of the
+// current run are generated, creating it on first use. It is named after the
+// build and the time at which it was created, so that the kept files of a
+// run can be found, and is unique, so that runs never share their files.
+func (p *Provisioner) stagingDir() (string, error) {
+	p.generatedMu.Lock()
+	defer p.generatedMu.Unlock()
+	if p.staging != "" {
+		return p.staging, nil
+	}
+
+	prefix := "packer-provisioner-ansible-"
+	if id := p.buildID(); id != "" {
+		prefix += id + "-"
+	}
+	prefix += time.Now().Format("20060102T150405") + "-"
+	dir, err := ioutil.TempDir(p.config.StagingDir, prefix)
+	if err != nil {
+		return "", err
+	}
+	p.staging = dir
+	return dir, nil
+}
+
+// tempFile creates a file, whose name starts with prefix, in the staging
+// directory of the run.
+func (p *Provisioner) tempFile(prefix string) (*os.File, error) {
+	dir, err := p.stagingDir()
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.TempFile(dir, prefix)
+}
+
+// tempDir creates a directory, whose name starts with prefix, in the staging
+// directory of the run.
+func (p *Provisioner) tempDir(prefix string) (string, error) {
+	dir, err := p.stagingDir()
+	if err != nil {
+		return "", err
+	}
+	return ioutil.TempDir(dir, prefix)
+}
@@ -0,0 +1,55 @@
+package ansible
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProvisioner_StagingDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var p Provisioner
+	p.config.StagingDir = dir
+	p.config.PackerBuildName = "amazon-ebs"
+	p.config.HostAlias = "default"
+
+	f, err := p.tempFile("passwords")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	f.Close()
+	p.track(f.Name())
+	if err := p.generateInventory(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	staging := filepath.Dir(f.Name())
+	if filepath.Dir(staging) != dir || !strings.HasPrefix(filepath.Base(staging), "packer-provisioner-ansible-amazon-ebs-") {
+		t.Fatalf("unexpected staging directory %s", staging)
+	}
+	if filepath.Dir(filepath.Dir(p.config.inventoryFile)) != staging {
+		t.Fatalf("expected the inventory %s in %s", p.config.inventoryFile, staging)
+	}
+
+	p.cleanup()
+	if _, err := os.Stat(staging); !os.IsNotExist(err) {
+		t.Fatalf("expected the staging directory to be removed: %v", err)
+	}
+
+	p.config.KeepInventoryFile = true
+	p.config.inventoryFile = ""
+	if err := p.generateInventory(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	p.cleanup()
+	if _, err := os.Stat(p.config.inventoryFile); err != nil {
+		t.Fatalf("expected the inventory to be kept in the staging directory: %s", err)
+	}
+}
@@ -0,0 +1,105 @@
+package ansible
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/packer/packer"
+)
+
+// prepareVirtualenv checks the ansible_virtualenv options and points the
+// ansible commands that are not paths at the virtualenv.
+func (p *Provisioner) prepareVirtualenv() []error {
+	if p.config.AnsibleVirtualenv == "" {
+		return nil
+	}
+	var errs []error
+	if p.config.AnsibleVirtualenvPython == "" {
+		p.config.AnsibleVirtualenvPython = "python3"
+	}
+	if p.config.AnsibleVirtualenvRequirements != "" {
+		if err := validateFileConfig(p.config.AnsibleVirtualenvRequirements, "ansible_virtualenv_requirements", true); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if p.config.ExecutionContainer != "" {
+		errs = append(errs, errors.New("ansible_virtualenv cannot be used with execution_container"))
+	}
+	if p.config.UseWSL {
+		errs = append(errs, errors.New("ansible_virtualenv cannot be used with use_wsl"))
+	}
+
+	dir, err := filepath.Abs(p.config.AnsibleVirtualenv)
+	if err != nil {
+		return append(errs, fmt.Errorf("ansible_virtualenv: %s", err))
+	}
+	p.config.AnsibleVirtualenv = dir
+	p.useAnsibleBin(filepath.Join(dir, "bin"))
+	return errs
+}
+
+// useAnsibleBin points the ansible commands that are not paths at bin.
+func (p *Provisioner) useAnsibleBin(bin string) {
+	for _, command := range []*string{
+		&p.config.Command,
+		&p.config.GalaxyCommand,
+		&p.config.AdhocCommand,
+		&p.config.NavigatorCommand,
+		&p.config.RunnerCommand,
+	} {
+		if *command != "" && !strings.ContainsRune(*command, filepath.Separator) {
+			*command = filepath.Join(bin, *command)
+		}
+	}
+}
+
+// setupVirtualenv creates the ansible_virtualenv, or recreates it when
+// ansible_virtualenv_refresh is set, and installs pkg, the package of
+// ansible, and the packages of ansible_virtualenv_requirements into it.
+func (p *Provisioner) setupVirtualenv(ui packer.Ui, pkg string) error {
+	dir := p.config.AnsibleVirtualenv
+	python := filepath.Join(dir, "bin", "python")
+
+	if p.config.AnsibleVirtualenvRefresh {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("Error removing the virtualenv %s: %s", dir, err)
+		}
+	}
+	if _, err := os.Stat(python); err != nil {
+		ui.Say(fmt.Sprintf("Creating the virtualenv %s", dir))
+		cmd := exec.Command(p.config.AnsibleVirtualenvPython, "-m", "venv", dir)
+		if err := runCommand(ui, cmd, ui.Message, ui.Message); err != nil {
+			return fmt.Errorf("Error creating the virtualenv %s: %s", dir, err)
+		}
+	}
+
+	args := []string{"-m", "pip", "install", pkg}
+	if p.config.AnsibleVirtualenvRequirements != "" {
+		args = append(args, "-r", p.config.AnsibleVirtualenvRequirements)
+	}
+	ui.Say(fmt.Sprintf("Installing Ansible into the virtualenv %s", dir))
+	cmd := exec.Command(python, args...)
+	cmd.Env = append(os.Environ(), p.env()...)
+	if err := runCommand(ui, cmd, ui.Message, ui.Message); err != nil {
+		return fmt.Errorf("Error installing Ansible into the virtualenv %s: %s", dir, err)
+	}
+	return nil
+}
+
+// virtualenvEnv returns the environment overrides that activate the
+// ansible_virtualenv.
+func (p *Provisioner) virtualenvEnv() []string {
+	if p.config.AnsibleVirtualenv == "" {
+		return nil
+	}
+	bin := filepath.Join(p.config.AnsibleVirtualenv, "bin")
+	path := bin
+	if env := os.Getenv("PATH"); env != "" {
+		path += string(filepath.ListSeparator) + env
+	}
+	return []string{"VIRTUAL_ENV=" + p.config.AnsibleVirtualenv, "PATH=" + path}
+}
@@ -0,0 +1,44 @@
+package ansible
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProvisioner_PrepareVirtualenv(t *testing.T) {
+	var p Provisioner
+	p.config.AnsibleVirtualenv = "/opt/ansible"
+	p.config.Command = "ansible-playbook"
+	p.config.GalaxyCommand = "/usr/local/bin/ansible-galaxy"
+	p.config.AdhocCommand = "ansible"
+	if errs := p.prepareVirtualenv(); len(errs) > 0 {
+		t.Fatalf("err: %v", errs)
+	}
+
+	if p.config.Command != filepath.Join("/opt/ansible", "bin", "ansible-playbook") {
+		t.Fatalf("expected command to be in the virtualenv, got %s", p.config.Command)
+	}
+	if p.config.AdhocCommand != filepath.Join("/opt/ansible", "bin", "ansible") {
+		t.Fatalf("expected adhoc_command to be in the virtualenv, got %s", p.config.AdhocCommand)
+	}
+	if p.config.GalaxyCommand != "/usr/local/bin/ansible-galaxy" {
+		t.Fatalf("expected galaxy_command to be kept, got %s", p.config.GalaxyCommand)
+	}
+	if p.config.AnsibleVirtualenvPython != "python3" {
+		t.Fatalf("expected ansible_virtualenv_python to default to python3, got %s", p.config.AnsibleVirtualenvPython)
+	}
+
+	env := p.virtualenvEnv()
+	if len(env) != 2 || env[0] != "VIRTUAL_ENV=/opt/ansible" || !strings.HasPrefix(env[1], "PATH="+filepath.Join("/opt/ansible", "bin")) {
+		t.Fatalf("unexpected environment %v", env)
+	}
+
+	p = Provisioner{}
+	p.config.AnsibleVirtualenv = "/opt/ansible"
+	p.config.AnsibleVirtualenvRequirements = "/nonexistent/requirements.txt"
+	p.config.ExecutionContainer = "ansible"
+	if errs := p.prepareVirtualenv(); len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+}
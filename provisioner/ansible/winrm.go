@@ -0,0 +1,58 @@
+package ansible
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// prepareWinRM sets the defaults of the winrm options, and checks them.
+func (p *Provisioner) prepareWinRM() []error {
+	var errs []error
+	if p.config.UseProxy != nil && *p.config.UseProxy {
+		errs = append(errs, errors.New("use_proxy cannot be used with the winrm connection"))
+	}
+	if p.config.GenerateSSHConfig {
+		errs = append(errs, errors.New("generate_ssh_config cannot be used with the winrm connection"))
+	}
+	if p.config.WinRMHost == "" {
+		errs = append(errs, errors.New("winrm_host must be specified for the winrm connection"))
+	}
+	if p.config.WinRMUsername == "" {
+		errs = append(errs, errors.New("winrm_username must be specified for the winrm connection"))
+	}
+	if p.config.WinRMPort == "" {
+		p.config.WinRMPort = "5985"
+		if p.config.WinRMUseSSL {
+			p.config.WinRMPort = "5986"
+		}
+	}
+	if _, err := strconv.ParseUint(p.config.WinRMPort, 10, 16); err != nil {
+		errs = append(errs, fmt.Errorf("winrm_port: %s must be a valid port", p.config.WinRMPort))
+	}
+	return errs
+}
+
+// winrmVars returns the variables with which ansible connects to the machine
+// over WinRM, at host and port as user. The password is passed in the file of
+// writePasswordVars instead.
+func (p *Provisioner) winrmVars(host, port, user string) []variable {
+	scheme := "http"
+	if p.config.WinRMUseSSL {
+		scheme = "https"
+	}
+	vars := []variable{
+		{"ansible_connection", "winrm"},
+		{"ansible_host", host},
+		{"ansible_port", port},
+		{"ansible_user", user},
+		{"ansible_winrm_scheme", scheme},
+	}
+	if p.config.WinRMTransport != "" {
+		vars = append(vars, variable{"ansible_winrm_transport", p.config.WinRMTransport})
+	}
+	if p.config.WinRMInsecure {
+		vars = append(vars, variable{"ansible_winrm_server_cert_validation", "ignore"})
+	}
+	return vars
+}
@@ -0,0 +1,61 @@
+package ansible
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestProvisioner_WinRM(t *testing.T) {
+	var p Provisioner
+	p.config.HostAlias = "default"
+	p.config.Connection = "winrm"
+	p.config.WinRMHost = "10.0.0.5"
+	p.config.WinRMUsername = "Administrator"
+	p.config.WinRMPassword = "s3cr3t"
+	p.config.WinRMUseSSL = true
+	p.config.WinRMInsecure = true
+
+	if errs := p.prepareWinRM(); len(errs) > 0 {
+		t.Fatalf("err: %v", errs)
+	}
+	if p.config.WinRMPort != "5986" {
+		t.Fatalf("expected winrm_port to default to 5986, got %s", p.config.WinRMPort)
+	}
+	if p.useProxy() {
+		t.Fatal("expected the winrm connection not to use the proxy")
+	}
+
+	var b bytes.Buffer
+	if err := p.writeInventory(&b); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := "default ansible_connection=winrm ansible_host=10.0.0.5 ansible_port=5986 ansible_user=Administrator ansible_winrm_scheme=https ansible_winrm_server_cert_validation=ignore\n"
+	if b.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, b.String())
+	}
+
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	p.config.StagingDir = dir
+	if err := p.writePasswordVars(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer p.cleanup()
+	if b, err := ioutil.ReadFile(p.config.passwordVarsFile); err != nil || string(b) != `{"ansible_password":"s3cr3t"}` {
+		t.Fatalf("expected the password in the password vars, got %s (%v)", b, err)
+	}
+
+	if d := p.inventoryTemplateData(); d.Address != "10.0.0.5" || d.User != "Administrator" {
+		t.Fatalf("unexpected template data: %+v", d)
+	}
+
+	p.config.WinRMHost = ""
+	if errs := p.prepareWinRM(); len(errs) == 0 {
+		t.Fatal("should error if winrm_host is missing")
+	}
+}
@@ -0,0 +1,119 @@
+package ansible
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// windowsPathPattern matches the absolute Windows paths in a string. A path
+// ends at whitespace, a quote, or a path list separator.
+var windowsPathPattern = regexp.MustCompile(`(^|[^A-Za-z0-9])([A-Za-z]):\\([^\s"';]*)`)
+
+// prepareWSL checks the WSL options.
+func (p *Provisioner) prepareWSL() []error {
+	var errs []error
+	if p.config.ProxyBindAddress != "" && net.ParseIP(p.config.ProxyBindAddress) == nil {
+		errs = append(errs, fmt.Errorf("proxy_bind_address: %s must be an IP address", p.config.ProxyBindAddress))
+	}
+	p.config.proxyAddress = p.config.ProxyBindAddress
+	if !p.config.UseWSL {
+		return errs
+	}
+	if p.config.ExecutionContainer != "" {
+		errs = append(errs, errors.New("use_wsl cannot be used with execution_container"))
+	}
+	return errs
+}
+
+// wslPath translates the absolute Windows paths in s to the paths at which
+// WSL mounts them, e.g. C:\Temp\hosts to /mnt/c/Temp/hosts.
+func wslPath(s string) string {
+	return windowsPathPattern.ReplaceAllStringFunc(s, func(m string) string {
+		sub := windowsPathPattern.FindStringSubmatch(m)
+		return sub[1] + "/mnt/" + strings.ToLower(sub[2]) + "/" + strings.Replace(sub[3], `\`, "/", -1)
+	})
+}
+
+// controllerPath returns path as ansible sees it: translated for WSL when
+// use_wsl is set.
+func (p *Provisioner) controllerPath(path string) string {
+	if p.config.UseWSL {
+		return wslPath(path)
+	}
+	return path
+}
+
+// wslArgs returns the arguments of wsl.exe to run the command name with args,
+// with the Windows paths in the arguments translated. wsl.exe runs the
+// command in the working directory of wsl.exe.
+func (p *Provisioner) wslArgs(name string, args []string) []string {
+	var wslArgs []string
+	if p.config.WSLDistribution != "" {
+		wslArgs = append(wslArgs, "--distribution", p.config.WSLDistribution)
+	}
+	wslArgs = append(wslArgs, "--exec", name)
+	for _, arg := range args {
+		wslArgs = append(wslArgs, wslPath(arg))
+	}
+	return wslArgs
+}
+
+// wslEnv returns the environment overrides env with the Windows paths, and
+// lists of them, translated, and WSLENV set so that wsl.exe passes them on.
+func wslEnv(env []string) []string {
+	var translated, names []string
+	for _, v := range env {
+		kv := strings.SplitN(v, "=", 2)
+		value := wslPath(kv[1])
+		if parts := strings.Split(value, ";"); len(parts) > 1 && value != kv[1] {
+			value = strings.Join(parts, ":")
+		}
+		translated = append(translated, kv[0]+"="+value)
+		names = append(names, kv[0]+"/u")
+	}
+	if len(names) == 0 {
+		return env
+	}
+	if wslenv := os.Getenv("WSLENV"); wslenv != "" {
+		names = append([]string{wslenv}, names...)
+	}
+	return append(translated, "WSLENV="+strings.Join(names, ":"))
+}
+
+// discoverWSLAddress sets the address of the SSH proxy to the address of the
+// Windows host as seen from WSL, the gateway of WSL's default route, so that
+// ansible in WSL 2 reaches the proxy.
+func (p *Provisioner) discoverWSLAddress() error {
+	var args []string
+	if p.config.WSLDistribution != "" {
+		args = append(args, "--distribution", p.config.WSLDistribution)
+	}
+	args = append(args, "--exec", "ip", "route", "show", "default")
+	out, err := exec.Command("wsl.exe", args...).Output()
+	if err != nil {
+		return err
+	}
+	addr := parseDefaultRoute(string(out))
+	if addr == "" {
+		return errors.New("WSL has no default route; set proxy_bind_address")
+	}
+	p.config.proxyAddress = addr
+	return nil
+}
+
+// parseDefaultRoute returns the gateway of the default route in the output of
+// ip route show default.
+func parseDefaultRoute(out string) string {
+	fields := strings.Fields(out)
+	for i := 0; i+2 < len(fields); i++ {
+		if fields[i] == "default" && fields[i+1] == "via" && net.ParseIP(fields[i+2]) != nil {
+			return fields[i+2]
+		}
+	}
+	return ""
+}